@@ -25,7 +25,7 @@ func TestMain(m *testing.M) {
 	svc := service.NewServiceMock()
 	svc = service.NewServiceLogging(svc, log)
 	go func() {
-		err := Serve(port, svc)
+		err := Serve(context.Background(), port, svc)
 		if err != nil {
 			log.Error(err.Error())
 		}
@@ -66,6 +66,17 @@ func TestServiceClient_Create(t *testing.T) {
 			},
 			err: status.Error(codes.AlreadyExists, "conflict"),
 		},
+		"invalid": {
+			req: &CreateRequest{
+				Url: "invalid",
+				Headers: []*Header{
+					{
+						Name: "",
+					},
+				},
+			},
+			err: status.Error(codes.InvalidArgument, "invalid"),
+		},
 	}
 	//
 	for k, c := range cases {
@@ -88,6 +99,9 @@ func TestServiceClient_Read(t *testing.T) {
 		groupId   string
 		userId    string
 		createdAt *timestamppb.Timestamp
+		state     State
+		countMsgs uint64
+		headers   []*Header
 		err       error
 	}{
 		"ok": {
@@ -97,6 +111,18 @@ func TestServiceClient_Read(t *testing.T) {
 			groupId:   "group0",
 			userId:    "user1",
 			createdAt: timestamppb.New(time.Date(2024, 11, 4, 14, 52, 0, 0, time.UTC)),
+			state:     State_RECEIVING,
+			countMsgs: 42,
+			headers: []*Header{
+				{
+					Name:   "Authorization",
+					Secret: true,
+				},
+				{
+					Name:  "Origin",
+					Value: "https://awakari.com",
+				},
+			},
 		},
 		"fail": {
 			req: &ReadRequest{
@@ -120,6 +146,15 @@ func TestServiceClient_Read(t *testing.T) {
 				assert.Equal(t, c.groupId, resp.GroupId)
 				assert.Equal(t, c.userId, resp.UserId)
 				assert.Equal(t, c.createdAt, resp.CreatedAt)
+				assert.Equal(t, c.state, resp.Status.State)
+				assert.Equal(t, c.countMsgs, resp.Status.CountMessages)
+				assert.Nil(t, resp.Status.LastErrorAt)
+				require.Equal(t, len(c.headers), len(resp.Headers))
+				for i, h := range c.headers {
+					assert.Equal(t, h.Name, resp.Headers[i].Name)
+					assert.Equal(t, h.Value, resp.Headers[i].Value)
+					assert.Equal(t, h.Secret, resp.Headers[i].Secret)
+				}
 			}
 		})
 	}
@@ -163,6 +198,56 @@ func TestServiceClient_Delete(t *testing.T) {
 	}
 }
 
+func TestServiceClient_Resume(t *testing.T) {
+	//
+	addr := fmt.Sprintf("localhost:%d", port)
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.Nil(t, err)
+	client := NewServiceClient(conn)
+	//
+	cases := map[string]struct {
+		req *ResumeRequest
+		err error
+	}{
+		"ok": {
+			req: &ResumeRequest{
+				Url: "url0",
+			},
+		},
+		"fail": {
+			req: &ResumeRequest{
+				Url: "fail",
+			},
+			err: status.Error(codes.Internal, "unexpected"),
+		},
+		"missing": {
+			req: &ResumeRequest{
+				Url: "missing",
+			},
+			err: status.Error(codes.NotFound, "not found"),
+		},
+		"running": {
+			req: &ResumeRequest{
+				Url: "running",
+			},
+			err: status.Error(codes.AlreadyExists, "conflict"),
+		},
+		"other replica": {
+			req: &ResumeRequest{
+				Url: "other_replica",
+			},
+			err: status.Error(codes.FailedPrecondition, "precondition failed"),
+		},
+	}
+	//
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			_, err := client.Resume(context.TODO(), c.req)
+			assert.ErrorIs(t, err, c.err)
+		})
+	}
+}
+
 func TestServiceClient_List(t *testing.T) {
 	//
 	addr := fmt.Sprintf("localhost:%d", port)
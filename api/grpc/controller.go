@@ -7,6 +7,7 @@ import (
 	"github.com/awakari/source-websocket/service"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 	"time"
 )
@@ -27,7 +28,30 @@ func (c controller) Create(ctx context.Context, req *CreateRequest) (resp *Creat
 	case "":
 		err = status.Error(codes.InvalidArgument, "empty url")
 	default:
-		err = c.svc.Create(ctx, req.Url, req.Req, req.GroupId, req.UserId, time.Now().UTC())
+		str := model.Stream{
+			CreatedAt:      time.Now().UTC(),
+			Request:        req.Req,
+			GroupId:        req.GroupId,
+			UserId:         req.UserId,
+			Headers:        decodeHeaders(req.Headers),
+			Subprotocols:   req.Subprotocols,
+			Keepalive:      decodeKeepalive(req.Keepalive),
+			Heartbeats:     decodeHeartbeats(req.Heartbeats),
+			Replies:        decodeReplies(req.Replies),
+			Handshake:      decodeHandshake(req.Handshake),
+			Format:         model.Format(req.Format),
+			Protobuf:       decodeProtobufSchema(req.Protobuf),
+			Compression:    decodeCompression(req.Compression),
+			ReadLimit:      req.ReadLimit,
+			Split:          req.Split,
+			PublishNonData: req.PublishNonData,
+			Converter:      req.Converter,
+			Mappings:       decodeMappings(req.Mappings),
+			Filter:         req.Filter,
+			Script:         req.Script,
+			Template:       req.Template,
+		}
+		err = c.svc.Create(ctx, req.Url, str)
 		err = translateError(err)
 	}
 	return
@@ -42,6 +66,32 @@ func (c controller) Read(ctx context.Context, req *ReadRequest) (resp *ReadRespo
 		resp.Req = str.Request
 		resp.GroupId = str.GroupId
 		resp.UserId = str.UserId
+		resp.Status = encodeStatus(str.Status)
+		resp.Headers = encodeHeaders(str.Headers)
+		resp.Subprotocols = str.Subprotocols
+		resp.Keepalive = encodeKeepalive(str.Keepalive)
+		resp.Heartbeats = encodeHeartbeats(str.Heartbeats)
+		resp.Replies = encodeReplies(str.Replies)
+		resp.Handshake = encodeHandshake(str.Handshake)
+		resp.Format = Format(str.Format)
+		resp.Compression = &Compression{
+			Deflate: DeflateMode(str.Compression.Deflate),
+			Payload: PayloadCompression(str.Compression.Payload),
+		}
+		resp.ReadLimit = str.ReadLimit
+		resp.Split = str.Split
+		resp.PublishNonData = str.PublishNonData
+		resp.Converter = str.Converter
+		resp.Mappings = encodeMappings(str.Mappings)
+		resp.Filter = str.Filter
+		resp.Script = str.Script
+		resp.Template = str.Template
+		if str.Format == model.FormatProtobuf {
+			resp.Protobuf = &ProtobufSchema{
+				Descriptors: str.Protobuf.Descriptors,
+				Message:     str.Protobuf.Message,
+			}
+		}
 	}
 	err = translateError(err)
 	return
@@ -54,6 +104,13 @@ func (c controller) Delete(ctx context.Context, req *DeleteRequest) (resp *Delet
 	return
 }
 
+func (c controller) Resume(ctx context.Context, req *ResumeRequest) (resp *ResumeResponse, err error) {
+	resp = &ResumeResponse{}
+	err = c.svc.Resume(ctx, req.Url, req.GroupId, req.UserId)
+	err = translateError(err)
+	return
+}
+
 func (c controller) List(ctx context.Context, req *ListRequest) (resp *ListResponse, err error) {
 	resp = &ListResponse{}
 	filter := model.Filter{}
@@ -76,12 +133,206 @@ func (c controller) List(ctx context.Context, req *ListRequest) (resp *ListRespo
 	return
 }
 
+func decodeHeaders(src []*Header) (dst []model.Header) {
+	for _, h := range src {
+		dst = append(dst, model.Header{
+			Name:   h.Name,
+			Value:  h.Value,
+			Secret: h.Secret,
+		})
+	}
+	return
+}
+
+func encodeHeaders(src []model.Header) (dst []*Header) {
+	for _, h := range src {
+		hdr := &Header{
+			Name:   h.Name,
+			Secret: h.Secret,
+		}
+		if !h.Secret {
+			hdr.Value = h.Value
+		}
+		dst = append(dst, hdr)
+	}
+	return
+}
+
+func decodeKeepalive(src *Keepalive) (dst model.Keepalive) {
+	if src != nil {
+		if src.PingInterval != nil {
+			dst.PingInterval = src.PingInterval.AsDuration()
+		}
+		if src.IdleTimeout != nil {
+			dst.IdleTimeout = src.IdleTimeout.AsDuration()
+		}
+	}
+	return
+}
+
+func encodeKeepalive(src model.Keepalive) (dst *Keepalive) {
+	dst = &Keepalive{}
+	if src.PingInterval != 0 {
+		dst.PingInterval = durationpb.New(src.PingInterval)
+	}
+	if src.IdleTimeout != 0 {
+		dst.IdleTimeout = durationpb.New(src.IdleTimeout)
+	}
+	return
+}
+
+func decodeHeartbeats(src []*Heartbeat) (dst []model.Heartbeat) {
+	for _, hb := range src {
+		dst = append(dst, model.Heartbeat{
+			Interval: hb.Interval.AsDuration(),
+			Message:  hb.Message,
+		})
+	}
+	return
+}
+
+func encodeHeartbeats(src []model.Heartbeat) (dst []*Heartbeat) {
+	for _, hb := range src {
+		dst = append(dst, &Heartbeat{
+			Interval: durationpb.New(hb.Interval),
+			Message:  hb.Message,
+		})
+	}
+	return
+}
+
+func decodeReplies(src []*Reply) (dst []model.Reply) {
+	for _, r := range src {
+		dst = append(dst, model.Reply{
+			Match:    r.Match,
+			Template: r.Template,
+		})
+	}
+	return
+}
+
+func encodeReplies(src []model.Reply) (dst []*Reply) {
+	for _, r := range src {
+		dst = append(dst, &Reply{
+			Match:    r.Match,
+			Template: r.Template,
+		})
+	}
+	return
+}
+
+func decodeMappings(src []*Mapping) (dst []model.Mapping) {
+	for _, m := range src {
+		dst = append(dst, model.Mapping{
+			Path:      m.Path,
+			Attribute: m.Attribute,
+			Type:      model.MappingType(m.Type),
+			Param:     m.Param,
+			Label:     m.Label,
+		})
+	}
+	return
+}
+
+func encodeMappings(src []model.Mapping) (dst []*Mapping) {
+	for _, m := range src {
+		dst = append(dst, &Mapping{
+			Path:      m.Path,
+			Attribute: m.Attribute,
+			Type:      MappingType(m.Type),
+			Param:     m.Param,
+			Label:     m.Label,
+		})
+	}
+	return
+}
+
+func decodeProtobufSchema(src *ProtobufSchema) (dst model.ProtobufSchema) {
+	if src != nil {
+		dst.Descriptors = src.Descriptors
+		dst.Message = src.Message
+	}
+	return
+}
+
+func decodeCompression(src *Compression) (dst model.Compression) {
+	if src != nil {
+		dst.Deflate = model.DeflateMode(src.Deflate)
+		dst.Payload = model.PayloadCompression(src.Payload)
+	}
+	return
+}
+
+func decodeHandshake(src []*HandshakeStep) (dst []model.HandshakeStep) {
+	for _, hs := range src {
+		step := model.HandshakeStep{
+			Message: hs.Message,
+			Ack:     hs.Ack,
+			Error:   hs.Error,
+		}
+		if hs.Timeout != nil {
+			step.Timeout = hs.Timeout.AsDuration()
+		}
+		dst = append(dst, step)
+	}
+	return
+}
+
+func encodeHandshake(src []model.HandshakeStep) (dst []*HandshakeStep) {
+	for _, hs := range src {
+		step := &HandshakeStep{
+			Message: hs.Message,
+			Ack:     hs.Ack,
+			Error:   hs.Error,
+		}
+		if hs.Timeout != 0 {
+			step.Timeout = durationpb.New(hs.Timeout)
+		}
+		dst = append(dst, step)
+	}
+	return
+}
+
+func encodeStatus(src model.Status) (dst *Status) {
+	dst = &Status{
+		State:             State(src.State),
+		UpdatedAt:         encodeTimestamp(src.UpdatedAt),
+		ConnectedAt:       encodeTimestamp(src.ConnectedAt),
+		LastMessageAt:     encodeTimestamp(src.LastMessageAt),
+		LastErrorAt:       encodeTimestamp(src.LastErrorAt),
+		LastError:         src.LastError,
+		Subprotocol:       src.Subprotocol,
+		PongAt:            encodeTimestamp(src.PongAt),
+		PingRtt:           durationpb.New(src.PingRtt),
+		CountMessages:     src.CountMessages,
+		CountEvents:       src.CountEvents,
+		CountErrors:       src.CountErrors,
+		CountIdleTimeouts: src.CountIdleTimeouts,
+		CountOversized:    src.CountOversized,
+		CountControl:      src.CountControl,
+		CountUnknown:      src.CountUnknown,
+		CountRejected:     src.CountRejected,
+	}
+	return
+}
+
+func encodeTimestamp(src time.Time) (dst *timestamppb.Timestamp) {
+	if !src.IsZero() {
+		dst = timestamppb.New(src.UTC())
+	}
+	return
+}
+
 func translateError(src error) (dst error) {
 	switch {
 	case errors.Is(src, service.ErrNotFound):
 		dst = status.Error(codes.NotFound, src.Error())
 	case errors.Is(src, service.ErrConflict):
 		dst = status.Error(codes.AlreadyExists, src.Error())
+	case errors.Is(src, service.ErrInvalid):
+		dst = status.Error(codes.InvalidArgument, src.Error())
+	case errors.Is(src, service.ErrPrecondition):
+		dst = status.Error(codes.FailedPrecondition, src.Error())
 	case src != nil:
 		dst = status.Error(codes.Internal, src.Error())
 	}
@@ -1,6 +1,8 @@
 package grpc
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/awakari/source-websocket/service"
 	"google.golang.org/grpc"
@@ -10,7 +12,8 @@ import (
 	"net"
 )
 
-func Serve(port uint16, search service.Service) (err error) {
+// Serve blocks until the context is cancelled, then stops accepting new calls and waits for the pending ones.
+func Serve(ctx context.Context, port uint16, search service.Service) (err error) {
 	srv := grpc.NewServer()
 	c := NewController(search)
 	RegisterServiceServer(srv, c)
@@ -18,7 +21,13 @@ func Serve(port uint16, search service.Service) (err error) {
 	grpc_health_v1.RegisterHealthServer(srv, health.NewServer())
 	conn, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err == nil {
+		stop := context.AfterFunc(ctx, srv.GracefulStop)
+		defer stop()
 		err = srv.Serve(conn)
+		// the context may be cancelled before serving started
+		if errors.Is(err, grpc.ErrServerStopped) {
+			err = nil
+		}
 	}
 	return
 }
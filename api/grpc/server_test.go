@@ -0,0 +1,28 @@
+package grpc
+
+import (
+	"context"
+	"github.com/awakari/source-websocket/service"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestServe_Stopped(t *testing.T) {
+	cases := map[string]struct {
+		cancelAfter time.Duration
+	}{
+		"cancelled before serving": {},
+		"cancelled while serving": {
+			cancelAfter: 100 * time.Millisecond,
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(c.cancelAfter, cancel)
+			err := Serve(ctx, port+1, service.NewServiceMock())
+			assert.Nil(t, err)
+		})
+	}
+}
@@ -6,9 +6,10 @@ import (
 )
 
 type Config struct {
-	Api ApiConfig
-	Db  DbConfig
-	Log struct {
+	Api     ApiConfig
+	Db      DbConfig
+	Handler HandlerConfig
+	Log     struct {
 		Level int `envconfig:"LOG_LEVEL" default:"-4" required:"true"`
 	}
 	Replica ReplicaConfig
@@ -39,7 +40,11 @@ type DbConfig struct {
 	Name     string `envconfig:"DB_NAME" default:"sources" required:"true"`
 	UserName string `envconfig:"DB_USERNAME" default:""`
 	Password string `envconfig:"DB_PASSWORD" default:""`
-	Table    struct {
+	Secret   struct {
+		// Key is the base64 encoded AES key (16, 24 or 32 bytes long) to encrypt the secret stream headers with.
+		Key string `envconfig:"DB_SECRET_KEY" default:""`
+	}
+	Table struct {
 		Name      string        `envconfig:"DB_TABLE_NAME" default:"websocket" required:"true"`
 		Retention time.Duration `envconfig:"DB_TABLE_RETENTION" default:"2160h" required:"true"`
 		Shard     bool          `envconfig:"DB_TABLE_SHARD" default:"true"`
@@ -50,6 +55,66 @@ type DbConfig struct {
 	}
 }
 
+type HandlerConfig struct {
+	Backoff struct {
+		// MaxElapsed is the maximum time to keep reconnecting before the stream is marked as failed.
+		MaxElapsed time.Duration `envconfig:"HANDLER_BACKOFF_MAX_ELAPSED" default:"15m" required:"true"`
+	}
+	Handshake struct {
+		// Timeout is the default maximum time to wait for the handshake step response.
+		Timeout time.Duration `envconfig:"HANDLER_HANDSHAKE_TIMEOUT" default:"10s" required:"true"`
+	}
+	Inflate struct {
+		// SizeMax limits the size of the inflated frame payload.
+		SizeMax int64 `envconfig:"HANDLER_INFLATE_SIZE_MAX" default:"16777216" required:"true"`
+	}
+	Keepalive struct {
+		// PingInterval is the default period to send the websocket pings, 0 means no pings.
+		PingInterval time.Duration `envconfig:"HANDLER_KEEPALIVE_PING_INTERVAL" default:"30s"`
+		// IdleTimeout is the default maximum time to wait for the next message before reconnecting, 0 means no timeout.
+		IdleTimeout time.Duration `envconfig:"HANDLER_KEEPALIVE_IDLE_TIMEOUT" default:"0"`
+	}
+	Read struct {
+		// Limit is the default max message size in bytes, the larger messages are skipped.
+		Limit int64 `envconfig:"HANDLER_READ_LIMIT" default:"1048576" required:"true"`
+	}
+	Restart struct {
+		// IntervalMax limits the delay before restarting the panicked stream handler.
+		IntervalMax time.Duration `envconfig:"HANDLER_RESTART_INTERVAL_MAX" default:"5m" required:"true"`
+	}
+	Retry struct {
+		// Interval is the period to resume the failed streams automatically, 0 means never.
+		Interval time.Duration `envconfig:"HANDLER_RETRY_INTERVAL" default:"1h"`
+	}
+	Script struct {
+		// StepsMax limits the Starlark computation steps of the stream script per message.
+		StepsMax uint64 `envconfig:"HANDLER_SCRIPT_STEPS_MAX" default:"100000" required:"true"`
+		// MemoryMax limits the bytes allocated by the stream script per message, estimated for every new value.
+		MemoryMax int `envconfig:"HANDLER_SCRIPT_MEMORY_MAX" default:"16777216" required:"true"`
+		// InputMax limits the count of the message values, including the nested ones, passed to the stream script.
+		InputMax int `envconfig:"HANDLER_SCRIPT_INPUT_MAX" default:"10000" required:"true"`
+		// EventsMax limits the count of the events returned by the stream script per message.
+		EventsMax int `envconfig:"HANDLER_SCRIPT_EVENTS_MAX" default:"100" required:"true"`
+		// AttrsMax limits the count of the attributes per event returned by the stream script.
+		AttrsMax int `envconfig:"HANDLER_SCRIPT_ATTRS_MAX" default:"100" required:"true"`
+		// StringLenMax limits the length of the event text and of the string attribute values returned by the script.
+		StringLenMax int `envconfig:"HANDLER_SCRIPT_STRING_LEN_MAX" default:"65536" required:"true"`
+	}
+	Shutdown struct {
+		// Timeout limits the time to close the stream handlers on the shutdown, including the pending publishing.
+		Timeout time.Duration `envconfig:"HANDLER_SHUTDOWN_TIMEOUT" default:"20s" required:"true"`
+	}
+	Skipped struct {
+		// LogInterval is the minimum period between logging the skipped message samples per stream, 0 means never.
+		LogInterval time.Duration `envconfig:"HANDLER_SKIPPED_LOG_INTERVAL" default:"0"`
+	}
+	Status struct {
+		// Interval is the minimum period between the stream status updates in the storage while the state is the same.
+		Interval time.Duration `envconfig:"HANDLER_STATUS_INTERVAL" default:"1m" required:"true"`
+		Timeout  time.Duration `envconfig:"HANDLER_STATUS_TIMEOUT" default:"10s" required:"true"`
+	}
+}
+
 type ReplicaConfig struct {
 	Count uint32 `envconfig:"REPLICA_COUNT" required:"true"`
 	Name  string `envconfig:"REPLICA_NAME" required:"true"`
@@ -10,13 +10,17 @@ import (
 	"github.com/awakari/source-websocket/service"
 	"github.com/awakari/source-websocket/service/converter"
 	"github.com/awakari/source-websocket/service/handler"
+	"github.com/awakari/source-websocket/storage"
 	"github.com/awakari/source-websocket/storage/mongo"
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 )
 
 func main() {
@@ -51,7 +55,8 @@ func main() {
 	svcPub = pub.NewLogging(svcPub, log)
 	log.Info("initialized the Awakari publish API client")
 
-	ctx := context.Background()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 	stor, err := mongo.NewStorage(ctx, cfg.Db)
 	if err != nil {
 		panic(err)
@@ -63,28 +68,39 @@ func main() {
 
 	handlersLock := &sync.Mutex{}
 	handlerByUrl := make(map[string]handler.Handler)
-	handlerFactory := handler.NewFactory(cfg.Api, conv, svcPub, log)
+	handlerFactory := handler.NewFactory(cfg.Api, cfg.Handler, conv, svcPub, stor, log)
+	handlerFactory = service.NewSupervisorFactory(handlerFactory, stor, cfg.Handler, log)
 
-	svc := service.NewService(stor, uint32(replicaIndex), handlersLock, handlerByUrl, handlerFactory)
+	svc := service.NewService(stor, uint32(replicaIndex), handlersLock, handlerByUrl, handlerFactory, cfg.Handler)
 	svc = service.NewServiceLogging(svc, log)
 	if replicaIndex > 0 {
-		err = resumeHandlers(ctx, log, svc, uint32(replicaIndex), handlersLock, handlerByUrl, handlerFactory)
-		if err != nil {
+		err = resumeHandlers(ctx, log, svc, stor, uint32(replicaIndex), handlersLock, handlerByUrl, handlerFactory)
+		// interrupted by the shutdown signal, the handlers resumed so far are stopped below
+		if err != nil && ctx.Err() == nil {
 			panic(err)
 		}
 	}
 
+	if cfg.Handler.Retry.Interval > 0 {
+		go retryFailedHandlers(ctx, log, svc, cfg.Handler.Retry.Interval, handlersLock, handlerByUrl)
+	}
+
 	log.Info(fmt.Sprintf("starting to listen the gRPC API @ port #%d...", cfg.Api.Port))
-	err = apiGrpc.Serve(cfg.Api.Port, svc)
+	err = apiGrpc.Serve(ctx, cfg.Api.Port, svc)
+
+	log.Info("shutting down, closing the stream handlers...")
+	stopHandlers(log, cfg.Handler.Shutdown.Timeout, handlersLock, handlerByUrl)
 	if err != nil {
 		panic(err)
 	}
+	log.Info("shutdown complete")
 }
 
 func resumeHandlers(
 	ctx context.Context,
 	log *slog.Logger,
 	svc service.Service,
+	stor storage.Storage,
 	replicaIndex uint32,
 	handlersLock *sync.Mutex,
 	handlerByUrl map[string]handler.Handler,
@@ -102,8 +118,16 @@ func resumeHandlers(
 			cursor = urls[len(urls)-1]
 			for _, url := range urls {
 				str, err = svc.Read(ctx, url)
-				if err == nil && str.Replica == replicaIndex {
-					resumeHandler(ctx, log, url, str, handlersLock, handlerByUrl, handlerFactory)
+				switch {
+				case err == nil && str.Replica == replicaIndex:
+					resumeHandler(log, url, str, handlersLock, handlerByUrl, handlerFactory)
+				case err != nil && ctx.Err() == nil:
+					// a single stream failure, e.g. the secret header can't be decrypted, should not affect others
+					log.Error(fmt.Sprintf("failed to resume the handler for %s: %s", url, err))
+					if str.Replica == replicaIndex {
+						failStream(ctx, log, stor, url, str.Status, err)
+					}
+					err = nil
 				}
 				if err != nil {
 					break
@@ -118,7 +142,6 @@ func resumeHandlers(
 }
 
 func resumeHandler(
-	ctx context.Context,
 	log *slog.Logger,
 	url string,
 	str model.Stream,
@@ -130,6 +153,91 @@ func resumeHandler(
 	defer handlersLock.Unlock()
 	h := handlerFactory(url, str)
 	handlerByUrl[url] = h
-	go h.Handle(ctx)
+	// handlers are stopped explicitly on shutdown, see stopHandlers
+	go h.Handle(context.Background())
 	log.Info(fmt.Sprintf("resumed handler for %s", url))
 }
+
+// failStream persists the failure of the stream that can't be resumed, the stream may be fixed and resumed later.
+func failStream(ctx context.Context, log *slog.Logger, stor storage.Storage, url string, st model.Status, cause error) {
+	t := time.Now().UTC()
+	st.State = model.StateFailed
+	st.UpdatedAt = t
+	st.LastErrorAt = t
+	st.LastError = cause.Error()
+	st.CountErrors++
+	err := stor.UpdateStatus(ctx, url, st)
+	if err != nil {
+		log.Warn(fmt.Sprintf("failed to update the status of %s: %s", url, err))
+	}
+}
+
+// stopHandlers closes all the stream handlers concurrently, so every connection gets the normal closure and every
+// pending event is published, but not longer than the specified timeout.
+func stopHandlers(
+	log *slog.Logger,
+	timeout time.Duration,
+	handlersLock *sync.Mutex,
+	handlerByUrl map[string]handler.Handler,
+) {
+	handlersLock.Lock()
+	defer handlersLock.Unlock()
+	wg := &sync.WaitGroup{}
+	for url, h := range handlerByUrl {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := h.Close()
+			if err != nil {
+				log.Warn(fmt.Sprintf("failed to close the handler for %s: %s", url, err))
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Warn("timeout while closing the stream handlers")
+	}
+	clear(handlerByUrl)
+}
+
+func retryFailedHandlers(
+	ctx context.Context,
+	log *slog.Logger,
+	svc service.Service,
+	interval time.Duration,
+	handlersLock *sync.Mutex,
+	handlerByUrl map[string]handler.Handler,
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		var urls []string
+		handlersLock.Lock()
+		for url, h := range handlerByUrl {
+			if h.Status().State == model.StateFailed {
+				urls = append(urls, url)
+			}
+		}
+		handlersLock.Unlock()
+		for _, url := range urls {
+			str, err := svc.Read(ctx, url)
+			if err == nil {
+				err = svc.Resume(ctx, url, str.GroupId, str.UserId)
+			}
+			if err != nil {
+				log.Warn(fmt.Sprintf("failed to retry the failed handler for %s: %s", url, err))
+			}
+		}
+	}
+}
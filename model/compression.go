@@ -0,0 +1,59 @@
+package model
+
+import "fmt"
+
+// Compression of the stream messages.
+type Compression struct {
+	// Deflate is the permessage-deflate websocket extension mode to negotiate.
+	Deflate DeflateMode
+	// Payload is the compression of the binary frames to inflate before decoding.
+	Payload PayloadCompression
+}
+
+type DeflateMode int
+
+const (
+	DeflateDisabled DeflateMode = iota
+	DeflateContextTakeover
+	DeflateNoContextTakeover
+)
+
+func (m DeflateMode) String() (str string) {
+	names := [...]string{
+		"Disabled",
+		"ContextTakeover",
+		"NoContextTakeover",
+	}
+	if m >= 0 && int(m) < len(names) {
+		str = names[m]
+	} else {
+		str = fmt.Sprintf("DeflateMode(%d)", int(m))
+	}
+	return
+}
+
+type PayloadCompression int
+
+const (
+	PayloadNone PayloadCompression = iota
+	PayloadGzip
+	// PayloadDeflate is the raw deflate w/o the zlib header.
+	PayloadDeflate
+	// PayloadAuto detects the gzip or zlib by the header, otherwise takes the frame as is.
+	PayloadAuto
+)
+
+func (c PayloadCompression) String() (str string) {
+	names := [...]string{
+		"None",
+		"Gzip",
+		"Deflate",
+		"Auto",
+	}
+	if c >= 0 && int(c) < len(names) {
+		str = names[c]
+	} else {
+		str = fmt.Sprintf("PayloadCompression(%d)", int(c))
+	}
+	return
+}
@@ -0,0 +1,49 @@
+package model
+
+import "fmt"
+
+// Format of the stream messages.
+type Format int
+
+const (
+	// FormatJson expects every frame to be a JSON object.
+	FormatJson Format = iota
+	// FormatText takes the whole frame as a text.
+	FormatText
+	// FormatLines takes every non-empty line of the frame as a separate text.
+	FormatLines
+	// FormatBinary takes the frame bytes as is.
+	FormatBinary
+	// FormatMsgpack expects every frame to be a MessagePack map.
+	FormatMsgpack
+	// FormatCbor expects every frame to be a CBOR map.
+	FormatCbor
+	// FormatProtobuf expects every frame to be a protobuf message described by the stream's ProtobufSchema.
+	FormatProtobuf
+)
+
+func (f Format) String() (str string) {
+	names := [...]string{
+		"Json",
+		"Text",
+		"Lines",
+		"Binary",
+		"Msgpack",
+		"Cbor",
+		"Protobuf",
+	}
+	if f >= 0 && int(f) < len(names) {
+		str = names[f]
+	} else {
+		str = fmt.Sprintf("Format(%d)", int(f))
+	}
+	return
+}
+
+// ProtobufSchema describes the protobuf messages of the stream.
+type ProtobufSchema struct {
+	// Descriptors is the serialized FileDescriptorSet, e.g. protoc --include_imports --descriptor_set_out=...
+	Descriptors []byte
+	// Message is the full name of the frame message type, e.g. transit_realtime.FeedMessage
+	Message string
+}
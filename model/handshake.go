@@ -0,0 +1,15 @@
+package model
+
+import "time"
+
+// HandshakeStep is the message to send after connecting, optionally waiting for the response before the next step.
+type HandshakeStep struct {
+	// Message is sent as is in a text frame.
+	Message string
+	// Ack is a JSON object pattern (same as Reply.Match) of the response to wait for, empty means not to wait.
+	Ack string
+	// Error is a JSON object pattern of the response meaning the subscription is rejected, requires the Ack.
+	Error string
+	// Timeout to wait for the response, zero means the default.
+	Timeout time.Duration
+}
@@ -0,0 +1,23 @@
+package model
+
+import "fmt"
+
+// Header is an HTTP header to send in the websocket handshake request.
+type Header struct {
+	Name  string
+	Value string
+	// Secret header value is stored encrypted and never returned back nor logged.
+	Secret bool
+}
+
+const SecretMask = "******"
+
+func (h Header) String() (s string) {
+	switch h.Secret {
+	case true:
+		s = fmt.Sprintf("%s: %s", h.Name, SecretMask)
+	default:
+		s = fmt.Sprintf("%s: %s", h.Name, h.Value)
+	}
+	return
+}
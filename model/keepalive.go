@@ -0,0 +1,11 @@
+package model
+
+import "time"
+
+type Keepalive struct {
+	// PingInterval is the period to send the websocket pings, zero means the default, negative means no pings.
+	PingInterval time.Duration
+	// IdleTimeout is the maximum time to wait for the next message before reconnecting, zero means the default,
+	// negative means no timeout.
+	IdleTimeout time.Duration
+}
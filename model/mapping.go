@@ -0,0 +1,46 @@
+package model
+
+import "fmt"
+
+// Mapping is the rule to convert the message value to the event attribute.
+type Mapping struct {
+	// Path is the dot separated path of the value in the message, e.g. "data.properties.mag".
+	Path string
+	// Attribute is the name of the event attribute to set, e.g. "magnitude".
+	Attribute string
+	Type      MappingType
+	// Param is the separator for MappingJoined and the prefix for MappingPrefixed, ignored otherwise.
+	Param string
+	// Label is optional, when set the "<label>: <value>" line is appended to the event text.
+	Label string
+}
+
+type MappingType int
+
+const (
+	MappingString MappingType = iota
+	// MappingInt sets the integer attribute when the value fits 32 bits, otherwise the string one.
+	MappingInt
+	// MappingTimestamp accepts the unix seconds, millis, micros or the RFC3339 string.
+	MappingTimestamp
+	// MappingJoined joins the array elements with the separator.
+	MappingJoined
+	// MappingPrefixed prepends the prefix to the value, e.g. the base URL to the id.
+	MappingPrefixed
+)
+
+func (t MappingType) String() (str string) {
+	names := [...]string{
+		"String",
+		"Int",
+		"Timestamp",
+		"Joined",
+		"Prefixed",
+	}
+	if t >= 0 && int(t) < len(names) {
+		str = names[t]
+	} else {
+		str = fmt.Sprintf("MappingType(%d)", int(t))
+	}
+	return
+}
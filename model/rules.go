@@ -0,0 +1,20 @@
+package model
+
+import "time"
+
+// Heartbeat is an application level message to send periodically while connected.
+type Heartbeat struct {
+	Interval time.Duration
+	// Message is sent as is in a text frame.
+	Message string
+}
+
+// Reply is the rule to answer the matching inbound message, e.g. {"ping":123} with {"pong":123}. The matching message
+// is not published.
+type Reply struct {
+	// Match is a JSON object. The inbound message matches when it has all the same fields with the same values, where
+	// null value matches any.
+	Match string
+	// Template is a Go text/template rendered with the matching message to get the reply.
+	Template string
+}
@@ -0,0 +1,67 @@
+package model
+
+import (
+	"fmt"
+	"time"
+)
+
+type State int
+
+const (
+	StateUnknown State = iota
+	StateDialing
+	StateSubscribed
+	StateReceiving
+	StateBackingOff
+	StateFailed
+	StateStopped
+)
+
+func (s State) String() (str string) {
+	names := [...]string{
+		"Unknown",
+		"Dialing",
+		"Subscribed",
+		"Receiving",
+		"BackingOff",
+		"Failed",
+		"Stopped",
+	}
+	if s >= 0 && int(s) < len(names) {
+		str = names[s]
+	} else {
+		str = fmt.Sprintf("State(%d)", int(s))
+	}
+	return
+}
+
+type Status struct {
+	State         State
+	UpdatedAt     time.Time
+	ConnectedAt   time.Time
+	LastMessageAt time.Time
+	LastErrorAt   time.Time
+	LastError     string
+	// Subprotocol is the websocket subprotocol selected by the server for the last connection, empty if none.
+	Subprotocol string
+	// PongAt is the time of the last successful websocket ping.
+	PongAt time.Time
+	// PingRtt is the round trip time of the last successful websocket ping.
+	PingRtt time.Duration
+	// CountMessages is the total count of the messages received from the stream.
+	CountMessages uint64
+	// CountEvents is the total count of the events published.
+	CountEvents uint64
+	// CountErrors is the total count of the connection, conversion and publishing failures.
+	CountErrors uint64
+	// CountIdleTimeouts is the total count of the reconnects caused by no message received in time.
+	CountIdleTimeouts uint64
+	// CountOversized is the total count of the messages skipped because of exceeding the read limit.
+	CountOversized uint64
+	// CountControl is the total count of the control messages skipped, e.g. heartbeats.
+	CountControl uint64
+	// CountUnknown is the total count of the messages skipped because of converted to nothing.
+	CountUnknown uint64
+	// CountRejected is the total count of the events not published because of the stream filter.
+	CountRejected uint64
+}
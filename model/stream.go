@@ -8,4 +8,40 @@ type Stream struct {
 	GroupId   string
 	UserId    string
 	Replica   uint32
+	Status    Status
+	Headers   []Header
+	// Format defines how to decode the stream messages.
+	Format Format
+	// Protobuf is required for the protobuf format only.
+	Protobuf    ProtobufSchema
+	Compression Compression
+	// Split is the dot separated path of the array in the message to publish every element of as a separate event,
+	// e.g. "events". The top-level array frame is split by "items".
+	Split string
+	// Converter is the name of the converter to use, e.g. "coinbase". When empty, it's selected by the URL.
+	Converter string
+	// Mappings are the rules to convert the message values to the event attributes in addition to the converter.
+	Mappings []Mapping
+	// Filter is the optional CEL expression deciding whether to publish the event, e.g.
+	// has(attrs.magnitude) && double(attrs.magnitude) >= 5.0. The variables are the decoded message "msg" and the event
+	// attributes "attrs". The event is rejected when the evaluation fails, e.g. because of the absent attribute, and
+	// the failure is kept in the Status.LastError.
+	Filter string
+	// Template is the optional Go text/template of the event text, replaces the converter's default one. The data is
+	// the decoded message "msg" and the event attribute values "attrs", e.g. {{with .attrs.magnitude}}M{{.}}{{end}}.
+	Template string
+	// Script is the optional Starlark source defining the function "transform(msg)" returning the events to publish,
+	// replaces the converter and the mappings when set.
+	Script string
+	// PublishNonData disables skipping the control and unknown messages, e.g. for debugging.
+	PublishNonData bool
+	// ReadLimit is the max message size in bytes, zero means the default. The larger messages are skipped.
+	ReadLimit int64
+	// Subprotocols are offered to the server in the preference order.
+	Subprotocols []string
+	Keepalive    Keepalive
+	Heartbeats   []Heartbeat
+	Replies      []Reply
+	// Handshake is sent in order after the Request, the stream messages are published only after the handshake is done.
+	Handshake []HandshakeStep
 }
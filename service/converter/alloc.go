@@ -0,0 +1,929 @@
+package converter
+
+import (
+	"fmt"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// allocBudget is the count of the bytes left for the script to allocate in the thread. Every operator, slice, method
+// and builtin returning a new value is charged by default, the size is estimated from the operands, the receiver and
+// the arguments before the call, so the script aborts before allocating too much. Only the ones known to return the
+// existing or the constant size values are free.
+type allocBudget struct {
+	limit int
+	left  int
+}
+
+const scriptLocalAlloc = "alloc"
+
+// scriptElemSize is the estimated size of the collection element, it's the size of the Starlark value interface.
+const scriptElemSize = 16
+
+// The names of the builtins inserted by boundAllocs start with "$", so the script can not define or override them.
+const allocNameAttr = "$attr"
+const allocNameSlice = "$slice"
+const allocNameSpread = "$spread"
+const allocNameNone = "$none"
+const allocPrefixIndex = "$[]"
+const allocPrefixUnary = "$u"
+
+// allocEstimate returns the estimated size of the value returned by the method or the builtin, the estimation may
+// stop as soon as the limit is exceeded.
+type allocEstimate func(recv starlark.Value, args starlark.Tuple, kwargs []starlark.Tuple, limit int) int
+
+// allocMethods estimate the result size of the methods, when it's not the default one: the sizes of the receiver and
+// of the arguments.
+var allocMethods = map[string]allocEstimate{
+	"append": func(recv starlark.Value, args starlark.Tuple, kwargs []starlark.Tuple, limit int) int {
+		return scriptElemSize
+	},
+	"extend": func(recv starlark.Value, args starlark.Tuple, kwargs []starlark.Tuple, limit int) int {
+		return collSize(args, kwargs, scriptElemSize, limit)
+	},
+	"format": func(recv starlark.Value, args starlark.Tuple, kwargs []starlark.Tuple, limit int) int {
+		s, _ := starlark.AsString(recv)
+		vals := append(starlark.Tuple{}, args...)
+		for _, kw := range kwargs {
+			vals = append(vals, kw[1])
+		}
+		return addSize(len(s), mulSize(strings.Count(s, "{"), reprSize(vals, limit)))
+	},
+	"insert": func(recv starlark.Value, args starlark.Tuple, kwargs []starlark.Tuple, limit int) int {
+		return scriptElemSize
+	},
+	"items": func(recv starlark.Value, args starlark.Tuple, kwargs []starlark.Tuple, limit int) int {
+		return mulSize(lenOf(recv, limit), 3*scriptElemSize)
+	},
+	"join": func(recv starlark.Value, args starlark.Tuple, kwargs []starlark.Tuple, limit int) (size int) {
+		sep, _ := starlark.AsString(recv)
+		if len(args) == 1 {
+			if iterable, ok := args[0].(starlark.Iterable); ok {
+				iter := iterable.Iterate()
+				defer iter.Done()
+				var elem starlark.Value
+				for iter.Next(&elem) && size <= limit {
+					s, _ := starlark.AsString(elem)
+					size = addSize(size, addSize(len(s), len(sep)))
+				}
+			}
+		}
+		return
+	},
+	"keys": func(recv starlark.Value, args starlark.Tuple, kwargs []starlark.Tuple, limit int) int {
+		return mulSize(lenOf(recv, limit), scriptElemSize)
+	},
+	"replace": func(recv starlark.Value, args starlark.Tuple, kwargs []starlark.Tuple, limit int) (size int) {
+		s, _ := starlark.AsString(recv)
+		size = len(s)
+		if len(args) >= 2 {
+			old, _ := starlark.AsString(args[0])
+			repl, _ := starlark.AsString(args[1])
+			count := strings.Count(s, old)
+			if len(args) > 2 {
+				if n, err := starlark.AsInt32(args[2]); err == nil && n >= 0 && n < count {
+					count = n
+				}
+			}
+			if len(repl) > len(old) {
+				size = addSize(size, mulSize(count, len(repl)-len(old)))
+			}
+		}
+		return
+	},
+	"rsplit": splitSize,
+	"setdefault": func(recv starlark.Value, args starlark.Tuple, kwargs []starlark.Tuple, limit int) int {
+		return 2 * scriptElemSize
+	},
+	"split": splitSize,
+	"splitlines": func(recv starlark.Value, args starlark.Tuple, kwargs []starlark.Tuple, limit int) int {
+		s, _ := starlark.AsString(recv)
+		return mulSize(strings.Count(s, "\n")+strings.Count(s, "\r")+1, scriptElemSize)
+	},
+	"update": func(recv starlark.Value, args starlark.Tuple, kwargs []starlark.Tuple, limit int) int {
+		return collSize(args, kwargs, 2*scriptElemSize, limit)
+	},
+	"values": func(recv starlark.Value, args starlark.Tuple, kwargs []starlark.Tuple, limit int) int {
+		return mulSize(lenOf(recv, limit), scriptElemSize)
+	},
+}
+
+// allocMethodsFree return the receiver part, the existing value or the constant size value, or the lazy iterable.
+var allocMethodsFree = map[string]bool{
+	"clear":          true,
+	"codepoint_ords": true,
+	"codepoints":     true,
+	"count":          true,
+	"elem_ords":      true,
+	"elems":          true,
+	"endswith":       true,
+	"find":           true,
+	"get":            true,
+	"index":          true,
+	"isalnum":        true,
+	"isalpha":        true,
+	"isdigit":        true,
+	"islower":        true,
+	"isspace":        true,
+	"istitle":        true,
+	"isupper":        true,
+	"lstrip":         true,
+	"partition":      true,
+	"pop":            true,
+	"popitem":        true,
+	"remove":         true,
+	"removeprefix":   true,
+	"removesuffix":   true,
+	"rfind":          true,
+	"rindex":         true,
+	"rpartition":     true,
+	"rstrip":         true,
+	"startswith":     true,
+	"strip":          true,
+}
+
+// allocBuiltins estimate the result size of the universal builtins, when it's not the default one: the sizes of the
+// arguments.
+var allocBuiltins = map[string]allocEstimate{
+	"bytes": func(recv starlark.Value, args starlark.Tuple, kwargs []starlark.Tuple, limit int) int {
+		return collSize(args, nil, 1, limit)
+	},
+	"dict": func(recv starlark.Value, args starlark.Tuple, kwargs []starlark.Tuple, limit int) int {
+		return collSize(args, kwargs, 2*scriptElemSize, limit)
+	},
+	"enumerate": func(recv starlark.Value, args starlark.Tuple, kwargs []starlark.Tuple, limit int) int {
+		return collSize(args, nil, 3*scriptElemSize, limit)
+	},
+	"fail": func(recv starlark.Value, args starlark.Tuple, kwargs []starlark.Tuple, limit int) int {
+		return reprSize(args, limit)
+	},
+	"list": func(recv starlark.Value, args starlark.Tuple, kwargs []starlark.Tuple, limit int) int {
+		return collSize(args, nil, scriptElemSize, limit)
+	},
+	"print": func(recv starlark.Value, args starlark.Tuple, kwargs []starlark.Tuple, limit int) int {
+		return reprSize(args, limit)
+	},
+	"repr": func(recv starlark.Value, args starlark.Tuple, kwargs []starlark.Tuple, limit int) int {
+		return reprSize(args, limit)
+	},
+	"reversed": func(recv starlark.Value, args starlark.Tuple, kwargs []starlark.Tuple, limit int) int {
+		return collSize(args, nil, scriptElemSize, limit)
+	},
+	"sorted": func(recv starlark.Value, args starlark.Tuple, kwargs []starlark.Tuple, limit int) int {
+		return collSize(args, nil, scriptElemSize, limit)
+	},
+	"str": func(recv starlark.Value, args starlark.Tuple, kwargs []starlark.Tuple, limit int) (size int) {
+		// the string is returned as is
+		if len(args) == 1 && args[0].Type() != "string" {
+			size = reprSize(args, limit)
+		}
+		return
+	},
+	"tuple": func(recv starlark.Value, args starlark.Tuple, kwargs []starlark.Tuple, limit int) int {
+		return collSize(args, nil, scriptElemSize, limit)
+	},
+	"zip": func(recv starlark.Value, args starlark.Tuple, kwargs []starlark.Tuple, limit int) int {
+		return collSize(args, nil, 2*scriptElemSize, limit)
+	},
+}
+
+// allocBuiltinsFree return the existing value, the constant size value or the lazy range.
+var allocBuiltinsFree = map[string]bool{
+	"all":     true,
+	"any":     true,
+	"bool":    true,
+	"chr":     true,
+	"dir":     true,
+	"float":   true,
+	"hasattr": true,
+	"hash":    true,
+	"len":     true,
+	"max":     true,
+	"min":     true,
+	"ord":     true,
+	"range":   true,
+	"type":    true,
+}
+
+// scriptPredeclared contains the builtins charging the allocations, the universal ones are overridden.
+var scriptPredeclared = newScriptPredeclared()
+
+func newScriptPredeclared() (predeclared starlark.StringDict) {
+	predeclared = starlark.StringDict{
+		allocNameAttr:   starlark.NewBuiltin(allocNameAttr, allocAttr),
+		allocNameSlice:  starlark.NewBuiltin(allocNameSlice, allocSlice),
+		allocNameSpread: starlark.NewBuiltin(allocNameSpread, allocSpread),
+		allocNameNone:   starlark.None,
+		"getattr":       starlark.NewBuiltin("getattr", allocAttr),
+	}
+	for op := syntax.PLUS; op <= syntax.GTGT; op++ {
+		name := allocBinaryName(op)
+		predeclared[name] = newAllocBinary(name, op)
+		name = allocAugmentedName(op)
+		predeclared[name] = newAllocAugmented(name, op)
+		name = allocPrefixIndex + name[1:]
+		predeclared[name] = newAllocAugmentedIndex(name, op)
+	}
+	for _, op := range []syntax.Token{syntax.PLUS, syntax.MINUS, syntax.TILDE} {
+		name := allocPrefixUnary + op.String()
+		predeclared[name] = newAllocUnary(name, op)
+	}
+	for name, v := range starlark.Universe {
+		fn, fnOk := v.(*starlark.Builtin)
+		switch {
+		case !fnOk:
+		case allocBuiltinsFree[name]:
+		case name == "getattr":
+		case name == "set":
+			// keep it resolved as the universal one, so it's still disabled
+		default:
+			est, estOk := allocBuiltins[name]
+			if !estOk {
+				est = argsSize
+			}
+			predeclared[name] = newAllocCall(fn, est)
+		}
+	}
+	return
+}
+
+func allocBinaryName(op syntax.Token) string {
+	return "$" + op.String()
+}
+
+// allocAugmentedName returns the name for "x op= y", the augmented assignment tokens have the same order as the binary
+// ones.
+func allocAugmentedName(op syntax.Token) string {
+	return "$" + (op + syntax.PLUS_EQ - syntax.PLUS).String()
+}
+
+// charge fails when the size exceeds the budget left in the thread, if any.
+func charge(thread *starlark.Thread, size int) (err error) {
+	b, _ := thread.Local(scriptLocalAlloc).(*allocBudget)
+	switch {
+	case b == nil:
+	case size > b.left:
+		b.left = 0
+		err = fmt.Errorf("too much memory allocated, limit is %d bytes", b.limit)
+	default:
+		b.left -= size
+	}
+	return
+}
+
+// chargeLimit returns the budget left, the estimation may stop as soon as it's exceeded.
+func chargeLimit(thread *starlark.Thread) (limit int) {
+	limit = math.MaxInt - 1
+	if b, _ := thread.Local(scriptLocalAlloc).(*allocBudget); b != nil {
+		limit = b.left
+	}
+	return
+}
+
+func newAllocBinary(name string, op syntax.Token) *starlark.Builtin {
+	return starlark.NewBuiltin(name, func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (z starlark.Value, err error) {
+		err = charge(thread, binarySize(op, args[0], args[1], chargeLimit(thread)))
+		if err == nil {
+			z, err = starlark.Binary(op, args[0], args[1])
+		}
+		return
+	})
+}
+
+func newAllocUnary(name string, op syntax.Token) *starlark.Builtin {
+	return starlark.NewBuiltin(name, func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (z starlark.Value, err error) {
+		err = charge(thread, sizeOf(args[0]))
+		if err == nil {
+			z, err = starlark.Unary(op, args[0])
+		}
+		return
+	})
+}
+
+// newAllocAugmented returns the builtin charging "x op= y" and returning y, so the operation itself is left in place.
+func newAllocAugmented(name string, op syntax.Token) *starlark.Builtin {
+	return starlark.NewBuiltin(name, func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (y starlark.Value, err error) {
+		y = args[1]
+		err = charge(thread, augmentedSize(op, args[0], y, chargeLimit(thread)))
+		return
+	})
+}
+
+// newAllocAugmentedIndex returns the builtin replacing the whole "x[i] op= y" statement.
+func newAllocAugmentedIndex(name string, op syntax.Token) *starlark.Builtin {
+	return starlark.NewBuiltin(name, func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (_ starlark.Value, err error) {
+		x, i, y := args[0], args[1], args[2]
+		var old starlark.Value
+		old, err = getIndex(x, i)
+		if err == nil {
+			err = charge(thread, augmentedSize(op, old, y, chargeLimit(thread)))
+		}
+		var z starlark.Value
+		if err == nil {
+			z, err = augmented(thread, op, old, y)
+		}
+		if err == nil {
+			err = setIndex(x, i, z)
+		}
+		return starlark.None, err
+	})
+}
+
+// newAllocCall returns the builtin charging the estimated result size before calling the function or the method.
+func newAllocCall(fn *starlark.Builtin, est allocEstimate) *starlark.Builtin {
+	return starlark.NewBuiltin(fn.Name(), func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (z starlark.Value, err error) {
+		err = charge(thread, est(fn.Receiver(), args, kwargs, chargeLimit(thread)))
+		if err == nil {
+			z, err = starlark.Call(thread, fn, args, kwargs)
+		}
+		return
+	})
+}
+
+// allocAttr implements "x.name" and "getattr(x, name)", the methods returned are charged.
+func allocAttr(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (v starlark.Value, err error) {
+	v, err = starlark.Call(thread, starlark.Universe["getattr"], args, kwargs)
+	if err == nil {
+		v = allocMethod(v)
+	}
+	return
+}
+
+// allocMethod wraps the method to charge its result size before the call, unless it's known to be free.
+func allocMethod(v starlark.Value) starlark.Value {
+	if m, ok := v.(*starlark.Builtin); ok && m.Receiver() != nil && !allocMethodsFree[m.Name()] {
+		est, estOk := allocMethods[m.Name()]
+		if !estOk {
+			est = argsSize
+		}
+		v = newAllocCall(m, est)
+	}
+	return v
+}
+
+// allocSlice implements "x[lo:hi:step]" like Starlark does.
+func allocSlice(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (v starlark.Value, err error) {
+	x := args[0]
+	sliceable, ok := x.(starlark.Sliceable)
+	if !ok {
+		err = fmt.Errorf("invalid slice operand %s", x.Type())
+	}
+	var start, end, step int
+	if err == nil {
+		start, end, step, err = sliceIndices(sliceable.Len(), args[1], args[2], args[3])
+	}
+	if err == nil {
+		err = charge(thread, sliceSize(x, start, end, step))
+	}
+	if err == nil {
+		v = sliceable.Slice(start, end, step)
+	}
+	return
+}
+
+// allocSpread charges the arguments tuple built from "*x" or "**x" in the call.
+func allocSpread(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (v starlark.Value, err error) {
+	v = args[0]
+	err = charge(thread, collSize(args, nil, 2*scriptElemSize, chargeLimit(thread)))
+	return
+}
+
+// augmented implements "x op= y" like Starlark does: the list is extended and the dict is updated in place.
+func augmented(thread *starlark.Thread, op syntax.Token, x, y starlark.Value) (z starlark.Value, err error) {
+	var method string
+	switch x.(type) {
+	case *starlark.List:
+		if _, ok := y.(starlark.Iterable); ok && op == syntax.PLUS {
+			method = "extend"
+		}
+	case *starlark.Dict:
+		if _, ok := y.(*starlark.Dict); ok && op == syntax.PIPE {
+			method = "update"
+		}
+	}
+	switch method {
+	case "":
+		z, err = starlark.Binary(op, x, y)
+	default:
+		var m starlark.Value
+		m, err = x.(starlark.HasAttrs).Attr(method)
+		if err == nil {
+			_, err = starlark.Call(thread, m, starlark.Tuple{y}, nil)
+			z = x
+		}
+	}
+	return
+}
+
+// getIndex implements "x[i]" like Starlark does.
+func getIndex(x, i starlark.Value) (v starlark.Value, err error) {
+	switch xt := x.(type) {
+	case starlark.Mapping:
+		var found bool
+		v, found, err = xt.Get(i)
+		if err == nil && !found {
+			err = fmt.Errorf("key %v not in %s", i, x.Type())
+		}
+	case starlark.Indexable:
+		var n int
+		n, err = index(xt, i)
+		if err == nil {
+			v = xt.Index(n)
+		}
+	default:
+		err = fmt.Errorf("unhandled index operation %s[%s]", x.Type(), i.Type())
+	}
+	return
+}
+
+// setIndex implements "x[i] = v" like Starlark does.
+func setIndex(x, i, v starlark.Value) (err error) {
+	switch xt := x.(type) {
+	case starlark.HasSetKey:
+		err = xt.SetKey(i, v)
+	case starlark.HasSetIndex:
+		var n int
+		n, err = index(xt, i)
+		if err == nil {
+			err = xt.SetIndex(n, v)
+		}
+	default:
+		err = fmt.Errorf("%s value does not support item assignment", x.Type())
+	}
+	return
+}
+
+func index(x starlark.Indexable, i starlark.Value) (n int, err error) {
+	n, err = starlark.AsInt32(i)
+	if err == nil {
+		orig := n
+		if n < 0 {
+			n += x.Len()
+		}
+		if n < 0 || n >= x.Len() {
+			err = fmt.Errorf("%s index %d out of range [%d:%d]", x.Type(), orig, -x.Len(), x.Len()-1)
+		}
+	} else {
+		err = fmt.Errorf("%s index: %s", x.Type(), err)
+	}
+	return
+}
+
+// sliceIndices resolves the slice bounds like Starlark does: the default and the negative ones are relative to the
+// length, the ones out of the range are clamped.
+func sliceIndices(n int, lo, hi, stride starlark.Value) (start, end, step int, err error) {
+	step = 1
+	if stride != starlark.None {
+		step, err = starlark.AsInt32(stride)
+		switch {
+		case err != nil:
+			err = fmt.Errorf("invalid slice step: %s", err)
+		case step == 0:
+			err = fmt.Errorf("zero is not a valid slice step")
+		}
+	}
+	if err == nil {
+		switch {
+		case step > 0:
+			start, end = 0, n
+			err = sliceIndex(lo, n, &start, "start")
+			if err == nil {
+				err = sliceIndex(hi, n, &end, "end")
+			}
+			start = min(max(start, 0), n)
+			end = max(min(max(end, 0), n), start)
+		default:
+			start, end = n-1, -1
+			err = sliceIndex(lo, n, &start, "start")
+			if err == nil {
+				err = sliceIndex(hi, n, &end, "end")
+			}
+			start = min(start, n-1)
+			end = max(end, -1)
+			start = max(start, end)
+		}
+	}
+	return
+}
+
+func sliceIndex(v starlark.Value, n int, i *int, name string) (err error) {
+	if v != starlark.None {
+		*i, err = starlark.AsInt32(v)
+		switch {
+		case err != nil:
+			err = fmt.Errorf("invalid %s index: %s", name, err)
+		case *i < 0:
+			*i += n
+		}
+	}
+	return
+}
+
+// sliceSize is the size of the slice copy, the strings and the bytes sliced w/o the step share the memory.
+func sliceSize(x starlark.Value, start, end, step int) (size int) {
+	var n int
+	switch {
+	case step > 0 && end > start:
+		n = (end - start + step - 1) / step
+	case step < 0 && start > end:
+		n = (start - end - step - 1) / -step
+	}
+	switch x.(type) {
+	case starlark.String, starlark.Bytes:
+		if step != 1 {
+			size = n
+		}
+	case *starlark.List, starlark.Tuple:
+		size = mulSize(n, scriptElemSize)
+	}
+	return
+}
+
+func binarySize(op syntax.Token, x, y starlark.Value, limit int) (size int) {
+	switch op {
+	case syntax.STAR:
+		xInt, xIsInt := x.(starlark.Int)
+		yInt, yIsInt := y.(starlark.Int)
+		switch {
+		case xIsInt && yIsInt:
+			size = addSize(sizeOf(x), sizeOf(y))
+		case xIsInt:
+			size = mulSize(sizeOf(y), repeatCount(xInt))
+		case yIsInt:
+			size = mulSize(sizeOf(x), repeatCount(yInt))
+		}
+	case syntax.PERCENT:
+		switch xt := x.(type) {
+		case starlark.String:
+			size = addSize(len(xt), mulSize(strings.Count(string(xt), "%"), reprSize(starlark.Tuple{y}, limit)))
+		default:
+			size = addSize(sizeOf(x), sizeOf(y))
+		}
+	case syntax.LTLT:
+		size = sizeOf(x)
+		if yInt, ok := y.(starlark.Int); ok {
+			size = addSize(size, repeatCount(yInt)/8+1)
+		}
+	default:
+		size = addSize(sizeOf(x), sizeOf(y))
+	}
+	return
+}
+
+// augmentedSize is the size of the operation result, or only of its growth when the value is extended in place.
+func augmentedSize(op syntax.Token, x, y starlark.Value, limit int) (size int) {
+	_, xIsList := x.(*starlark.List)
+	_, xIsDict := x.(*starlark.Dict)
+	switch {
+	case xIsList && op == syntax.PLUS:
+		size = mulSize(lenOf(y, limit), scriptElemSize)
+	case xIsDict && op == syntax.PIPE:
+		size = sizeOf(y)
+	default:
+		size = binarySize(op, x, y, limit)
+	}
+	return
+}
+
+func repeatCount(i starlark.Int) (n int) {
+	n64, ok := i.Int64()
+	if ok && n64 > 0 && n64 <= math.MaxInt32 {
+		n = int(n64)
+	}
+	return
+}
+
+// splitSize is the size of the list of the parts, the parts share the memory with the string.
+func splitSize(recv starlark.Value, args starlark.Tuple, kwargs []starlark.Tuple, limit int) (size int) {
+	s, _ := starlark.AsString(recv)
+	var sep string
+	if len(args) > 0 {
+		sep, _ = starlark.AsString(args[0])
+	}
+	n := 1
+	switch sep {
+	case "":
+		space := true
+		for _, r := range s {
+			if !unicode.IsSpace(r) && space {
+				n++
+			}
+			space = unicode.IsSpace(r)
+		}
+	default:
+		n += strings.Count(s, sep)
+	}
+	return mulSize(n, scriptElemSize)
+}
+
+// argsSize is the default estimation: the result is not larger than the receiver and the arguments together.
+func argsSize(recv starlark.Value, args starlark.Tuple, kwargs []starlark.Tuple, limit int) (size int) {
+	if recv != nil {
+		size = sizeOf(recv)
+	}
+	for _, arg := range args {
+		size = addSize(size, sizeOf(arg))
+	}
+	for _, kw := range kwargs {
+		size = addSize(size, sizeOf(kw[1]))
+	}
+	return
+}
+
+// sizeOf estimates the value size w/o the nested values.
+func sizeOf(v starlark.Value) (size int) {
+	switch vt := v.(type) {
+	case starlark.String:
+		size = len(vt)
+	case starlark.Bytes:
+		size = len(vt)
+	case starlark.Int:
+		size = 8
+		if _, ok := vt.Int64(); !ok {
+			size = vt.BigInt().BitLen() / 8
+		}
+	case *starlark.Dict:
+		size = mulSize(vt.Len(), 2*scriptElemSize)
+	default:
+		size = mulSize(max(starlark.Len(v), 0), scriptElemSize)
+	}
+	return
+}
+
+// lenOf returns the count of the elements, the iterable w/o the length is iterated until the count exceeds the limit.
+func lenOf(v starlark.Value, limit int) (n int) {
+	n = starlark.Len(v)
+	if n < 0 {
+		n = 0
+		if iterable, ok := v.(starlark.Iterable); ok {
+			iter := iterable.Iterate()
+			defer iter.Done()
+			var elem starlark.Value
+			for n <= limit && iter.Next(&elem) {
+				n++
+			}
+		}
+	}
+	return
+}
+
+// collSize estimates the size of the collection built from the arguments, per element of every one.
+func collSize(args starlark.Tuple, kwargs []starlark.Tuple, elemSize, limit int) (size int) {
+	for _, arg := range args {
+		switch at := arg.(type) {
+		case starlark.String:
+			size = addSize(size, mulSize(len(at), elemSize))
+		case starlark.Bytes:
+			size = addSize(size, mulSize(len(at), elemSize))
+		default:
+			size = addSize(size, mulSize(lenOf(arg, limit), elemSize))
+		}
+	}
+	size = addSize(size, mulSize(len(kwargs), elemSize))
+	return
+}
+
+// reprSize estimates the length of the string representation, it stops as soon as the limit is exceeded. The
+// explicit stack is used, so the deep or cyclic values are handled too.
+func reprSize(v starlark.Value, limit int) (size int) {
+	stack := []starlark.Value{v}
+	for len(stack) > 0 && size <= limit {
+		v, stack = stack[len(stack)-1], stack[:len(stack)-1]
+		switch vt := v.(type) {
+		case starlark.String:
+			size = addSize(size, len(vt)+2)
+		case starlark.Bytes:
+			size = addSize(size, len(vt)+3)
+		case starlark.Int:
+			size = addSize(size, len(strconv.Itoa(math.MaxInt)))
+			if _, ok := vt.Int64(); !ok {
+				size = addSize(size, vt.BigInt().BitLen()/3)
+			}
+		case *starlark.Dict:
+			size = addSize(size, 2)
+			for _, item := range vt.Items() {
+				if size > limit {
+					break
+				}
+				size = addSize(size, 4)
+				stack = append(stack, item[0], item[1])
+			}
+		case starlark.Indexable:
+			size = addSize(size, 2)
+			for i := 0; i < vt.Len() && size <= limit; i++ {
+				size = addSize(size, 2)
+				stack = append(stack, vt.Index(i))
+			}
+		default:
+			size = addSize(size, len(strconv.Itoa(math.MaxInt)))
+		}
+	}
+	return
+}
+
+func addSize(a, b int) (size int) {
+	size = math.MaxInt
+	if a <= math.MaxInt-b {
+		size = a + b
+	}
+	return
+}
+
+func mulSize(a, b int) (size int) {
+	switch {
+	case a <= 0 || b <= 0:
+	case a > math.MaxInt/b:
+		size = math.MaxInt
+	default:
+		size = a * b
+	}
+	return
+}
+
+// boundAllocs rewrites the parsed script so every operation returning a new value calls the builtin charging the
+// allocation first: "x + y" becomes "$+(x, y)", "x.upper" becomes "$attr(x, 'upper')", "x[1:]" becomes
+// "$slice(x, 1, $none, $none)" and so on.
+func boundAllocs(f *syntax.File) {
+	boundStmts(f.Stmts)
+}
+
+func boundStmts(stmts []syntax.Stmt) {
+	for i, stmt := range stmts {
+		stmts[i] = boundStmt(stmt)
+	}
+}
+
+func boundStmt(stmt syntax.Stmt) syntax.Stmt {
+	switch st := stmt.(type) {
+	case *syntax.AssignStmt:
+		boundTarget(st.LHS)
+		st.RHS = boundExpr(st.RHS)
+		if st.Op >= syntax.PLUS_EQ && st.Op <= syntax.GTGT_EQ {
+			name := allocAugmentedName(st.Op - syntax.PLUS_EQ + syntax.PLUS)
+			switch lhs := st.LHS.(type) {
+			case *syntax.Ident:
+				x := &syntax.Ident{NamePos: lhs.NamePos, Name: lhs.Name}
+				st.RHS = allocCall(st.OpPos, name, x, st.RHS)
+			case *syntax.IndexExpr:
+				stmt = &syntax.ExprStmt{X: allocCall(st.OpPos, allocPrefixIndex+name[1:], lhs.X, lhs.Y, st.RHS)}
+			}
+		}
+	case *syntax.DefStmt:
+		boundParams(st.Params)
+		boundStmts(st.Body)
+	case *syntax.ExprStmt:
+		st.X = boundExpr(st.X)
+	case *syntax.ForStmt:
+		boundTarget(st.Vars)
+		st.X = boundExpr(st.X)
+		boundStmts(st.Body)
+	case *syntax.WhileStmt:
+		st.Cond = boundExpr(st.Cond)
+		boundStmts(st.Body)
+	case *syntax.IfStmt:
+		st.Cond = boundExpr(st.Cond)
+		boundStmts(st.True)
+		boundStmts(st.False)
+	case *syntax.ReturnStmt:
+		if st.Result != nil {
+			st.Result = boundExpr(st.Result)
+		}
+	}
+	return stmt
+}
+
+// boundTarget rewrites the expressions evaluated within the assignment target, but not the target itself.
+func boundTarget(target syntax.Expr) {
+	switch tt := target.(type) {
+	case *syntax.IndexExpr:
+		tt.X = boundExpr(tt.X)
+		tt.Y = boundExpr(tt.Y)
+	case *syntax.DotExpr:
+		tt.X = boundExpr(tt.X)
+	case *syntax.ParenExpr:
+		boundTarget(tt.X)
+	case *syntax.ListExpr:
+		for _, elem := range tt.List {
+			boundTarget(elem)
+		}
+	case *syntax.TupleExpr:
+		for _, elem := range tt.List {
+			boundTarget(elem)
+		}
+	}
+}
+
+// boundParams rewrites the default values of the parameters.
+func boundParams(params []syntax.Expr) {
+	for _, param := range params {
+		if p, ok := param.(*syntax.BinaryExpr); ok && p.Op == syntax.EQ {
+			p.Y = boundExpr(p.Y)
+		}
+	}
+}
+
+func boundExprs(exprs []syntax.Expr) {
+	for i, expr := range exprs {
+		exprs[i] = boundExpr(expr)
+	}
+}
+
+func boundExpr(expr syntax.Expr) syntax.Expr {
+	switch et := expr.(type) {
+	case *syntax.BinaryExpr:
+		et.X = boundExpr(et.X)
+		et.Y = boundExpr(et.Y)
+		if et.Op >= syntax.PLUS && et.Op <= syntax.GTGT {
+			expr = allocCall(et.OpPos, allocBinaryName(et.Op), et.X, et.Y)
+		}
+	case *syntax.CallExpr:
+		et.Fn = boundExpr(et.Fn)
+		for i, arg := range et.Args {
+			switch at := arg.(type) {
+			case *syntax.UnaryExpr:
+				// "*x" and "**x" are the only unary expressions w/o the operand evaluated
+				switch at.Op {
+				case syntax.STAR, syntax.STARSTAR:
+					at.X = allocCall(at.OpPos, allocNameSpread, boundExpr(at.X))
+				default:
+					et.Args[i] = boundExpr(arg)
+				}
+			default:
+				et.Args[i] = boundExpr(arg)
+			}
+		}
+	case *syntax.Comprehension:
+		et.Body = boundExpr(et.Body)
+		for _, clause := range et.Clauses {
+			switch ct := clause.(type) {
+			case *syntax.ForClause:
+				boundTarget(ct.Vars)
+				ct.X = boundExpr(ct.X)
+			case *syntax.IfClause:
+				ct.Cond = boundExpr(ct.Cond)
+			}
+		}
+	case *syntax.CondExpr:
+		et.Cond = boundExpr(et.Cond)
+		et.True = boundExpr(et.True)
+		et.False = boundExpr(et.False)
+	case *syntax.DictExpr:
+		boundExprs(et.List)
+	case *syntax.DictEntry:
+		et.Key = boundExpr(et.Key)
+		et.Value = boundExpr(et.Value)
+	case *syntax.DotExpr:
+		name := &syntax.Literal{
+			Token:    syntax.STRING,
+			TokenPos: et.NamePos,
+			Raw:      strconv.Quote(et.Name.Name),
+			Value:    et.Name.Name,
+		}
+		expr = allocCall(et.Dot, allocNameAttr, boundExpr(et.X), name)
+	case *syntax.IndexExpr:
+		et.X = boundExpr(et.X)
+		et.Y = boundExpr(et.Y)
+	case *syntax.LambdaExpr:
+		boundParams(et.Params)
+		et.Body = boundExpr(et.Body)
+	case *syntax.ListExpr:
+		boundExprs(et.List)
+	case *syntax.TupleExpr:
+		boundExprs(et.List)
+	case *syntax.ParenExpr:
+		et.X = boundExpr(et.X)
+	case *syntax.SliceExpr:
+		args := []syntax.Expr{boundExpr(et.X)}
+		for _, e := range []syntax.Expr{et.Lo, et.Hi, et.Step} {
+			switch e {
+			case nil:
+				args = append(args, &syntax.Ident{NamePos: et.Lbrack, Name: allocNameNone})
+			default:
+				args = append(args, boundExpr(e))
+			}
+		}
+		expr = allocCall(et.Lbrack, allocNameSlice, args...)
+	case *syntax.UnaryExpr:
+		if et.X != nil {
+			et.X = boundExpr(et.X)
+		}
+		switch et.Op {
+		case syntax.PLUS, syntax.MINUS, syntax.TILDE:
+			expr = allocCall(et.OpPos, allocPrefixUnary+et.Op.String(), et.X)
+		}
+	}
+	return expr
+}
+
+func allocCall(pos syntax.Position, name string, args ...syntax.Expr) *syntax.CallExpr {
+	return &syntax.CallExpr{
+		Fn:     &syntax.Ident{NamePos: pos, Name: name},
+		Lparen: pos,
+		Args:   args,
+		Rparen: pos,
+	}
+}
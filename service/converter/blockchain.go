@@ -6,6 +6,33 @@ import (
 
 // for details see: https://www.blockchain.com/explorer/api/api_websocket
 
+var textBlockchainBlock = mustTemplate("blockchain", `{{if has .attrs "object"}}New blockchain {{.attrs.object}} created
+{{end}}{{if has .attrs "xreward"}}Reward: {{.attrs.xreward}}
+{{end}}{{if has .attrs "xblockindex"}}Index: {{.attrs.xblockindex}}
+{{end}}{{if has .attrs "xhash"}}Hash: {{.attrs.xhash}}
+{{end}}`)
+
+var convSchemaBlockchainBlock = map[string]any{
+	"op": convertOpFunc("action"),
+	"x": map[string]any{
+		"txIndexes":        toAttrStringJoinedFunc("xtxindexes", " "),
+		"nTx":              toAttrInt32ElseStringFunc("xntx"),
+		"totalBTCSent":     toAttrInt32ElseStringFunc("xtotalbtcsent"),
+		"estimatedBTCSent": toAttrInt32ElseStringFunc("xestimatedbtcsent"),
+		"reward":           toAttrStringFunc("xreward"),
+		"size":             toAttrInt32ElseStringFunc("xsize"),
+		"blockIndex":       toAttrStringFunc("xblockindex"),
+		"prevBlockIndex":   toAttrInt32ElseStringFunc("xprevblockindex"),
+		"height":           toAttrInt32ElseStringFunc("xheight"),
+		"hash":             toAttrStringFunc("xhash"),
+		"mrklRoot":         toAttrStringFunc("xmrklroot"),
+		"version":          toAttrInt32ElseStringFunc("xversion"),
+		"time":             toAttrTimestampFunc("time"),
+		"bits":             toAttrInt32ElseStringFunc("xbits"),
+		"nonce":            toAttrInt32ElseStringFunc("nonce"),
+	},
+}
+
 func convertBlockchainBlockCreate(evt *pb.CloudEvent) {
 	evt.Attributes["action"] = &pb.CloudEventAttributeValue{
 		Attr: &pb.CloudEventAttributeValue_CeString{
@@ -17,5 +44,4 @@ func convertBlockchainBlockCreate(evt *pb.CloudEvent) {
 			CeString: "block",
 		},
 	}
-	evt.Data.(*pb.CloudEvent_TextData).TextData = "New blockchain block created\n" + evt.Data.(*pb.CloudEvent_TextData).TextData
 }
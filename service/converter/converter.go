@@ -11,11 +11,51 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"text/template"
 	"time"
 )
 
 type Service interface {
-	Convert(src string, raw map[string]any) (evt *pb.CloudEvent, err error)
+	// Convert returns zero or more events for the message. The events converted successfully are returned even if
+	// the conversion of others fails. The control and unknown messages are skipped unless Source.PublishNonData.
+	Convert(src Source, raw map[string]any) (evts []*pb.CloudEvent, skipped Skipped, err error)
+}
+
+// Skipped counts the messages not converted to the events.
+type Skipped struct {
+	// Control messages carry no data, e.g. heartbeats or subscription acknowledgements.
+	Control int
+	// Unknown messages match nothing in the schema.
+	Unknown int
+	// Rejected events are converted but not accepted by the Source.Filter.
+	Rejected int
+}
+
+// Filter returns true to keep the event converted from the message, the message is the split element if any.
+type Filter func(msg map[string]any, evt *pb.CloudEvent) (ok bool, err error)
+
+// Source describes the stream the message to convert comes from.
+type Source struct {
+	Url string
+	// Subprotocol is the websocket subprotocol negotiated with the server, empty if none. It selects the converter
+	// together with the Url, see registry.
+	Subprotocol string
+	// Split is the dot separated path of the array to convert every element of as a separate message, e.g. "events"
+	// or "data.trades". The message w/o the array is converted as is.
+	Split string
+	// PublishNonData disables skipping the control and unknown messages, e.g. for debugging.
+	PublishNonData bool
+	// Converter is the name of the registered converter to use, see Supported. When empty, the converter is selected
+	// by the URL.
+	Converter string
+	// Mappings are optional, see CompileMappings. They take precedence over the converter's schema for the same keys.
+	Mappings *Mappings
+	// Template is optional, see CompileTemplate. It takes precedence over the converter's default one.
+	Template *template.Template
+	// Script is optional, built by CompileScript. It replaces the converter and the schema when set.
+	Script *Script
+	// Filter is optional, the filter error is returned joined with the conversion ones.
+	Filter Filter
 }
 
 type svc struct {
@@ -26,59 +66,23 @@ type ConvertFunc func(evt *pb.CloudEvent, v any) (err error)
 
 const ksuidEnthropyLenMax = 16
 
-var convSchema = map[string]any{
-	"action":        toAttrStringFunc("action"),
-	"best_ask":      toAttrStringFunc("bestbask"),
-	"best_ask_size": toAttrStringFunc("bestasksize"),
-	"best_bid":      toAttrStringFunc("bestbid"),
-	"best_bid_size": toAttrStringFunc("bestbidsize"),
-	"data": map[string]any{
-		"properties": map[string]any{
-			"auth":          toAttrStringFunc("subject"),
-			"depth":         toAttrStringWithPrefixFunc("elevation", "-"),
-			"flynn_region":  convertEarthquakeLocationFunc("location"),
-			"lat":           toAttrStringFunc("latitude"),
-			"lon":           toAttrStringFunc("longitude"),
-			"mag":           convertEarthquakeMagnitudeFunc("magnitude"),
-			"magtype":       toAttrStringFunc("magnitudetype"),
-			"sourcecatalog": toAttrStringFunc("sourcecatalog"),
-			"sourceid":      toAttrStringFunc("sourceid"),
-			"time":          toAttrTimestampFunc("time"),
-			"unid":          toAttrStringWithPrefixFunc("objecturl", seismicportalEuEventDetailsHtmlUnid),
-		},
-	},
-	"high_24h":   toAttrInt32ElseStringFunc("high24h"),
-	"last_size":  toAttrInt32ElseStringFunc("lastsize"),
-	"low_24h":    toAttrInt32ElseStringFunc("low24h"),
-	"op":         convertOpFunc("action"),
-	"open_24h":   toAttrInt32ElseStringFunc("open24h"),
-	"price":      convertPriceFunc("offersprice"),
-	"product_id": convertTickerProductIdFunc("productid"),
-	"sequence":   toAttrInt32ElseStringFunc("sequence"),
-	"side":       convertTickerSideFunc("side"),
-	"time":       toAttrTimestampFunc("time"),
-	"trade_id":   toAttrInt32ElseStringFunc("tradeid"),
-	"volume_24h": toAttrInt32ElseStringFunc("volume24h"),
-	"volume_30d": toAttrInt32ElseStringFunc("volume30d"),
-	"x": map[string]any{
-		"txIndexes":        toAttrStringJoinedFunc("xtxindexes", " "),
-		"nTx":              toAttrInt32ElseStringFunc("xntx"),
-		"totalBTCSent":     toAttrInt32ElseStringFunc("xtotalbtcsent"),
-		"estimatedBTCSent": toAttrInt32ElseStringFunc("xestimatedbtcsent"),
-		"reward":           toStringAttrAndAppendTextLabelFunc("xreward", "Reward"),
-		"size":             toAttrInt32ElseStringFunc("xsize"),
-		"blockIndex":       toStringAttrAndAppendTextLabelFunc("xblockindex", "Index"),
-		"prevBlockIndex":   toAttrInt32ElseStringFunc("xprevblockindex"),
-		"height":           toAttrInt32ElseStringFunc("xheight"),
-		"hash":             toStringAttrAndAppendTextLabelFunc("xhash", "Hash"),
-		"mrklRoot":         toAttrStringFunc("xmrklroot"),
-		"version":          toAttrInt32ElseStringFunc("xversion"),
-		"time":             toAttrTimestampFunc("time"),
-		"bits":             toAttrInt32ElseStringFunc("xbits"),
-		"nonce":            toAttrInt32ElseStringFunc("nonce"),
-	},
+// KeyText is the key of the message decoded from the plain text frame or line.
+const KeyText = "text"
+
+// KeyItems is the key of the message decoded from the top-level array frame.
+const KeyItems = "items"
+
+// KeyBinary is the key of the message decoded from the binary frame, the value is the frame bytes as is.
+const KeyBinary = "binary"
+
+var convSchemaGeneric = map[string]any{
+	KeyBinary: toBinaryDataFunc(KeyBinary),
+	KeyText:   toTextDataFunc(KeyText),
 }
 
+// convSchemaControl converts nothing, it's used for the messages not carrying any data, e.g. heartbeats.
+var convSchemaControl = map[string]any{}
+
 var ErrConversion = errors.New("conversion failure")
 
 func NewService(et string) Service {
@@ -87,9 +91,178 @@ func NewService(et string) Service {
 	}
 }
 
-func (s svc) Convert(src string, raw map[string]any) (evt *pb.CloudEvent, err error) {
+func (s svc) Convert(src Source, raw map[string]any) (evts []*pb.CloudEvent, skipped Skipped, err error) {
+	msgs := split(raw, src.Split)
+	switch src.Script {
+	case nil:
+		evts, skipped, err = s.convertSchema(src, msgs)
+	default:
+		evts, skipped, err = s.convertScripted(src, msgs)
+	}
+	return
+}
+
+func (s svc) convertSchema(src Source, msgs []map[string]any) (evts []*pb.CloudEvent, skipped Skipped, err error) {
+	conv := lookup(src.Converter, src.Url, src.Subprotocol)
+	for i, msg := range msgs {
+		evt := s.newEvent(src, i)
+		if id, ok := naturalId(src.Url, conv.keys, msg); ok {
+			evt.Id = id
+		}
+		schema := route(msg, conv.discriminators, conv.fallback)
+		if len(schema) > 0 && src.Mappings != nil {
+			schema = mergeSchemas(schema, src.Mappings.schema)
+		}
+		errConv := convert(evt, msg, schema)
+		if conv.flatten && len(schema) > 0 {
+			flatten(evt, msg, schema)
+		}
+		var publish bool
+		switch {
+		case errConv != nil:
+		case src.PublishNonData:
+			publish = true
+		case len(schema) == 0:
+			skipped.Control++
+		case isEmpty(evt):
+			skipped.Unknown++
+		default:
+			publish = true
+		}
+		if publish {
+			publish, errConv = src.accept(msg, evt, &skipped)
+		}
+		if publish {
+			errConv = finishText(src, conv.text, evt, msg)
+		}
+		switch {
+		case errConv != nil:
+			err = errors.Join(err, errConv)
+		case publish:
+			evts = append(evts, evt)
+		}
+	}
+	return
+}
+
+// accept counts the event rejected by the filter.
+func (src Source) accept(msg map[string]any, evt *pb.CloudEvent, skipped *Skipped) (ok bool, err error) {
+	ok = src.Filter == nil
+	if !ok {
+		ok, err = src.Filter(msg, evt)
+		if err == nil && !ok {
+			skipped.Rejected++
+		}
+	}
+	return
+}
+
+// finishText renders the text by the stream template or the default one, then appends the mapping labels.
+func finishText(src Source, tmplDefault *template.Template, evt *pb.CloudEvent, msg map[string]any) (err error) {
+	tmpl := src.Template
+	if tmpl == nil {
+		tmpl = tmplDefault
+	}
+	err = render(tmpl, evt, msg)
+	if err == nil {
+		src.Mappings.appendLabels(evt)
+	}
+	return
+}
+
+func (s svc) convertScripted(src Source, msgs []map[string]any) (evts []*pb.CloudEvent, skipped Skipped, err error) {
+	var seq int
+	newEvent := func() (evt *pb.CloudEvent) {
+		evt = s.newEvent(src, seq)
+		seq++
+		return
+	}
+	for _, msg := range msgs {
+		msgEvts, errScript := src.Script.transform(msg, newEvent)
+		switch {
+		case errScript != nil:
+			err = errors.Join(err, errScript)
+		case len(msgEvts) == 0:
+			skipped.Control++
+		}
+		for _, evt := range msgEvts {
+			switch {
+			case src.PublishNonData, !isEmpty(evt):
+				accepted, errText := src.accept(msg, evt, &skipped)
+				if accepted {
+					errText = render(src.Template, evt, msg)
+				}
+				switch {
+				case errText != nil:
+					err = errors.Join(err, errText)
+				case accepted:
+					evts = append(evts, evt)
+				}
+			default:
+				skipped.Unknown++
+			}
+		}
+	}
+	return
+}
+
+// isEmpty returns true when the event has neither data nor attributes.
+func isEmpty(evt *pb.CloudEvent) (empty bool) {
+	empty = len(evt.Attributes) == 0
+	if empty {
+		switch dt := evt.Data.(type) {
+		case *pb.CloudEvent_TextData:
+			empty = dt.TextData == ""
+		case *pb.CloudEvent_BinaryData:
+			empty = len(dt.BinaryData) == 0
+		}
+	}
+	return
+}
 
-	entropy := []byte(src)
+// split returns the elements of the array at the path, the top-level non-container fields of the message are added to
+// every element unless present. The elements which are not objects are skipped. When the path is empty or there's no
+// array at the path, the message is returned as is.
+func split(raw map[string]any, path string) (msgs []map[string]any) {
+	var arr []any
+	var arrOk bool
+	if path != "" {
+		var node any = raw
+		for _, k := range strings.Split(path, ".") {
+			m, ok := node.(map[string]any)
+			if !ok {
+				node = nil
+				break
+			}
+			node = m[k]
+		}
+		arr, arrOk = node.([]any)
+	}
+	switch arrOk {
+	case true:
+		for _, e := range arr {
+			if msg, ok := e.(map[string]any); ok {
+				for k, v := range raw {
+					switch v.(type) {
+					case map[string]any, []any:
+					default:
+						if _, present := msg[k]; !present {
+							msg[k] = v
+						}
+					}
+				}
+				msgs = append(msgs, msg)
+			}
+		}
+	default:
+		msgs = append(msgs, raw)
+	}
+	return
+}
+
+// newEvent creates the event w/o data, seq is the number of the event converted from the same message.
+func (s svc) newEvent(src Source, seq int) (evt *pb.CloudEvent) {
+	entropy := []byte(src.Url)
 	switch {
 	case len(entropy) < ksuidEnthropyLenMax:
 		for _ = range ksuidEnthropyLenMax - len(entropy) {
@@ -105,23 +278,23 @@ func (s svc) Convert(src string, raw map[string]any) (evt *pb.CloudEvent, err er
 	entropy[1] ^= byte(tNanos << 16)
 	entropy[2] ^= byte(tNanos << 8)
 	entropy[3] ^= byte(tNanos) // least significant byte of tNanos
+	// the events from the same message may be created within the same nanosecond
+	entropy[4] ^= byte(seq >> 8)
+	entropy[5] ^= byte(seq)
 
-	var id ksuid.KSUID
-	id, err = ksuid.FromParts(t, entropy)
+	id, err := ksuid.FromParts(t, entropy)
 	if err != nil {
 		id = ksuid.New() // fallback
 	}
 
 	evt = &pb.CloudEvent{
 		Id:          id.String(),
-		Source:      src,
+		Source:      src.Url,
 		SpecVersion: model.CeSpecVersion,
 		Type:        s.et,
 		Attributes:  make(map[string]*pb.CloudEventAttributeValue),
 		Data:        &pb.CloudEvent_TextData{},
 	}
-
-	err = convert(evt, raw, convSchema)
 	return
 }
 
@@ -153,11 +326,27 @@ func convertOpFunc(k string) ConvertFunc {
 	}
 }
 
-func convertPriceFunc(k string) ConvertFunc {
-	attrSetFunc := toAttrInt32ElseStringFunc(k)
+func toTextDataFunc(k string) ConvertFunc {
 	return func(evt *pb.CloudEvent, v any) (err error) {
-		evt.Data.(*pb.CloudEvent_TextData).TextData += fmt.Sprintf("Price: %s\n", v)
-		err = attrSetFunc(evt, v)
+		var s string
+		s, err = toString(k, v)
+		if err == nil {
+			evt.Data.(*pb.CloudEvent_TextData).TextData += s
+		}
+		return
+	}
+}
+
+func toBinaryDataFunc(k string) ConvertFunc {
+	return func(evt *pb.CloudEvent, v any) (err error) {
+		switch vt := v.(type) {
+		case []byte:
+			evt.Data = &pb.CloudEvent_BinaryData{
+				BinaryData: vt,
+			}
+		default:
+			err = fmt.Errorf("%w: key: %s, value: %v, type: %s, expected bytes", ErrConversion, k, v, reflect.TypeOf(v))
+		}
 		return
 	}
 }
@@ -416,19 +605,3 @@ func toInt32(v any) (i int32, ok bool) {
 	}
 	return
 }
-
-func toStringAttrAndAppendTextLabelFunc(k, lbl string) ConvertFunc {
-	return func(evt *pb.CloudEvent, v any) (err error) {
-		var s string
-		s, err = toString(k, v)
-		if err == nil {
-			evt.Attributes[k] = &pb.CloudEventAttributeValue{
-				Attr: &pb.CloudEventAttributeValue_CeString{
-					CeString: s,
-				},
-			}
-			evt.Data.(*pb.CloudEvent_TextData).TextData += fmt.Sprintf("%s: %s\n", lbl, s)
-		}
-		return
-	}
-}
@@ -0,0 +1,509 @@
+package converter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestSvc_Convert_Split(t *testing.T) {
+	s := NewService("com_awakari_websocket_v1")
+	cases := map[string]struct {
+		split  string
+		raw    map[string]any
+		prices []string
+		err    error
+	}{
+		"no split": {
+			raw: map[string]any{
+				"price": "1.5",
+			},
+			prices: []string{
+				"1.5",
+			},
+		},
+		"nested array": {
+			split: "events",
+			raw: map[string]any{
+				"product_id": "BTC-USD",
+				"events": []any{
+					map[string]any{
+						"price": "1.5",
+					},
+					map[string]any{
+						"price":      "2.5",
+						"product_id": "ETH-USD",
+					},
+				},
+			},
+			prices: []string{
+				"1.5",
+				"2.5",
+			},
+		},
+		"deep path": {
+			split: "data.trades",
+			raw: map[string]any{
+				"data": map[string]any{
+					"trades": []any{
+						map[string]any{
+							"price": "1.5",
+						},
+					},
+				},
+			},
+			prices: []string{
+				"1.5",
+			},
+		},
+		"top-level array w/ non-object elements": {
+			split: KeyItems,
+			raw: map[string]any{
+				KeyItems: []any{
+					"EVENT",
+					"sub1",
+					map[string]any{
+						"price": "3.5",
+					},
+				},
+			},
+			prices: []string{
+				"3.5",
+			},
+		},
+		"no array at path": {
+			split: "events",
+			raw: map[string]any{
+				"type":  "ticker",
+				"price": "1.5",
+			},
+			prices: []string{
+				"1.5",
+			},
+		},
+		"empty array": {
+			split: "events",
+			raw: map[string]any{
+				"events": []any{},
+			},
+		},
+		"partial failure": {
+			split: "events",
+			raw: map[string]any{
+				"events": []any{
+					map[string]any{
+						"price": "1.5",
+					},
+					map[string]any{
+						"time": true,
+					},
+				},
+			},
+			prices: []string{
+				"1.5",
+			},
+			err: ErrConversion,
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			evts, _, err := s.Convert(Source{Url: "wss://ws-feed.exchange.coinbase.com", Split: c.split}, c.raw)
+			assert.ErrorIs(t, err, c.err)
+			require.Len(t, evts, len(c.prices))
+			ids := map[string]bool{}
+			for i, evt := range evts {
+				assert.Equal(t, c.prices[i], evt.Attributes["offersprice"].GetCeString())
+				ids[evt.Id] = true
+			}
+			assert.Len(t, ids, len(evts))
+		})
+	}
+}
+
+func TestSvc_Convert_Route(t *testing.T) {
+	s := NewService("com_awakari_websocket_v1")
+	cases := map[string]struct {
+		url         string
+		subprotocol string
+		raw         map[string]any
+		attrs       []string
+	}{
+		"coinbase ticker": {
+			url: "wss://ws-feed.exchange.coinbase.com",
+			raw: map[string]any{
+				"type":       "ticker",
+				"product_id": "BTC-USD",
+				"price":      "97123.45",
+				"time":       "2025-01-01T00:00:00Z",
+			},
+			attrs: []string{
+				"offersprice",
+				"productid",
+				"time",
+			},
+		},
+		"coinbase heartbeat": {
+			url: "wss://ws-feed.exchange.coinbase.com",
+			raw: map[string]any{
+				"type":       "heartbeat",
+				"product_id": "BTC-USD",
+				"sequence":   90,
+				"time":       "2025-01-01T00:00:00Z",
+			},
+		},
+		"coinbase subscriptions": {
+			url: "wss://ws-feed.exchange.coinbase.com",
+			raw: map[string]any{
+				"type": "subscriptions",
+				"channels": []any{
+					map[string]any{
+						"name": "ticker",
+					},
+				},
+			},
+		},
+		"blockchain block": {
+			url: "wss://ws.blockchain.info/inv",
+			raw: map[string]any{
+				"op": "block",
+				"x": map[string]any{
+					"height": 42,
+					"time":   1735689600,
+				},
+			},
+			attrs: []string{
+				"action",
+				"object",
+				"time",
+				"xheight",
+			},
+		},
+		"seismicportal w/ unexpected top-level time": {
+			url: "wss://www.seismicportal.eu/standing_order/websocket",
+			raw: map[string]any{
+				"action": "create",
+				"time":   "not a timestamp",
+				"data": map[string]any{
+					"properties": map[string]any{
+						"mag": 4.2,
+					},
+				},
+			},
+			attrs: []string{
+				"action",
+				"magnitude",
+			},
+		},
+		"unknown type uses generic": {
+			url: "wss://ws-feed.exchange.coinbase.com",
+			raw: map[string]any{
+				"type":  "trade",
+				"price": "1.5",
+			},
+		},
+		"coinbase l2update uses generic": {
+			url: "wss://ws-feed.exchange.coinbase.com",
+			raw: map[string]any{
+				"type":       "l2update",
+				"product_id": "BTC-USD",
+				"time":       "2025-01-01T00:00:00Z",
+				"changes": []any{
+					[]any{
+						"buy",
+						"97123.45",
+						"0.5",
+					},
+				},
+			},
+		},
+		"coinbase match uses generic": {
+			url: "wss://ws-feed.exchange.coinbase.com",
+			raw: map[string]any{
+				"type":       "match",
+				"product_id": "BTC-USD",
+				"trade_id":   10,
+				"side":       "sell",
+				"price":      "97123.45",
+				"size":       "0.01",
+				"time":       "2025-01-01T00:00:00Z",
+			},
+		},
+		"coinbase snapshot uses generic": {
+			url: "wss://ws-feed.exchange.coinbase.com",
+			raw: map[string]any{
+				"type":       "snapshot",
+				"product_id": "BTC-USD",
+				"bids": []any{
+					[]any{
+						"97123.45",
+						"0.5",
+					},
+				},
+				"asks": []any{
+					[]any{
+						"97124.45",
+						"0.5",
+					},
+				},
+			},
+		},
+		"coinbase w/o type uses default": {
+			url: "wss://ws-feed.exchange.coinbase.com",
+			raw: map[string]any{
+				"product_id": "BTC-USD",
+				"price":      "1.5",
+			},
+			attrs: []string{
+				"offersprice",
+				"productid",
+			},
+		},
+		"no discriminator uses default": {
+			url: "wss://example.com",
+			raw: map[string]any{
+				"text": "hello",
+			},
+		},
+		"graphql next": {
+			url:         "wss://example.com/graphql",
+			subprotocol: "graphql-transport-ws",
+			raw: map[string]any{
+				"id":   "1",
+				"type": "next",
+				"payload": map[string]any{
+					"data": map[string]any{
+						"price": 1.5,
+					},
+				},
+			},
+			attrs: []string{
+				"payloaddataprice",
+			},
+		},
+		"graphql next w/o subprotocol uses generic": {
+			url: "wss://example.com/graphql",
+			raw: map[string]any{
+				"id":   "1",
+				"type": "next",
+				"payload": map[string]any{
+					"data": map[string]any{
+						"price": 1.5,
+					},
+				},
+			},
+			attrs: []string{
+				"id2",
+				"type2",
+				"payloaddataprice",
+			},
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			src := Source{
+				Url:            c.url,
+				Subprotocol:    c.subprotocol,
+				PublishNonData: true,
+			}
+			evts, _, err := s.Convert(src, c.raw)
+			require.Nil(t, err)
+			require.Len(t, evts, 1)
+			var attrs []string
+			for a := range evts[0].Attributes {
+				attrs = append(attrs, a)
+			}
+			assert.ElementsMatch(t, c.attrs, attrs)
+		})
+	}
+}
+
+func TestSvc_Convert_Skip(t *testing.T) {
+	s := NewService("com_awakari_websocket_v1")
+	cases := map[string]struct {
+		raw            map[string]any
+		split          string
+		subprotocol    string
+		publishNonData bool
+		count          int
+		skipped        Skipped
+	}{
+		"data": {
+			raw: map[string]any{
+				"text": "hello",
+			},
+			count: 1,
+		},
+		"control": {
+			raw: map[string]any{
+				"type": "heartbeat",
+			},
+			skipped: Skipped{
+				Control: 1,
+			},
+		},
+		"unknown": {
+			raw: map[string]any{
+				"foo": "bar",
+			},
+			skipped: Skipped{
+				Unknown: 1,
+			},
+		},
+		"empty text": {
+			raw: map[string]any{
+				"text": "",
+			},
+			skipped: Skipped{
+				Unknown: 1,
+			},
+		},
+		"unrouted type": {
+			raw: map[string]any{
+				"type":       "l2update",
+				"product_id": "BTC-USD",
+				"changes": []any{
+					[]any{
+						"buy",
+						"97123.45",
+						"0.5",
+					},
+				},
+			},
+			skipped: Skipped{
+				Unknown: 1,
+			},
+		},
+		"mixed split": {
+			split: "items",
+			raw: map[string]any{
+				"items": []any{
+					map[string]any{
+						"type":  "ticker",
+						"price": "1.5",
+					},
+					map[string]any{
+						"type": "heartbeat",
+					},
+					map[string]any{
+						"foo": "bar",
+					},
+				},
+			},
+			count: 1,
+			skipped: Skipped{
+				Control: 1,
+				Unknown: 1,
+			},
+		},
+		"publish non-data": {
+			raw: map[string]any{
+				"type": "heartbeat",
+			},
+			publishNonData: true,
+			count:          1,
+		},
+		"control by subprotocol": {
+			raw: map[string]any{
+				"type": "connection_ack",
+			},
+			subprotocol: "graphql-transport-ws",
+			skipped: Skipped{
+				Control: 1,
+			},
+		},
+		"unknown w/o subprotocol": {
+			raw: map[string]any{
+				"type": "connection_ack",
+			},
+			skipped: Skipped{
+				Unknown: 1,
+			},
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			src := Source{
+				Url:            "wss://ws-feed.exchange.coinbase.com",
+				Subprotocol:    c.subprotocol,
+				Split:          c.split,
+				PublishNonData: c.publishNonData,
+			}
+			evts, skipped, err := s.Convert(src, c.raw)
+			require.Nil(t, err)
+			assert.Len(t, evts, c.count)
+			assert.Equal(t, c.skipped, skipped)
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	cases := map[string]struct {
+		name        string
+		url         string
+		subprotocol string
+		out         string
+	}{
+		"seismicportal": {
+			url: "wss://www.seismicportal.eu/standing_order/websocket",
+			out: NameSeismicportal,
+		},
+		"seismicportal other path": {
+			url: "wss://www.seismicportal.eu/other",
+			out: NameGeneric,
+		},
+		"coinbase subdomain": {
+			url: "wss://ws-feed.exchange.coinbase.com",
+			out: NameCoinbase,
+		},
+		"coinbase lookalike": {
+			url: "wss://notcoinbase.com",
+			out: NameGeneric,
+		},
+		"blockchain": {
+			url: "wss://ws.blockchain.info/inv",
+			out: NameBlockchain,
+		},
+		"unknown": {
+			url: "wss://example.com",
+			out: NameGeneric,
+		},
+		"override": {
+			name: NameGeneric,
+			url:  "wss://ws-feed.exchange.coinbase.com",
+			out:  NameGeneric,
+		},
+		"invalid url": {
+			url: "::",
+			out: NameGeneric,
+		},
+		"graphql": {
+			url:         "wss://example.com/graphql",
+			subprotocol: "graphql-transport-ws",
+			out:         NameGraphql,
+		},
+		"graphql legacy": {
+			url:         "wss://example.com/graphql",
+			subprotocol: "graphql-ws",
+			out:         NameGraphql,
+		},
+		"graphql subprotocol precedes url": {
+			url:         "wss://ws-feed.exchange.coinbase.com",
+			subprotocol: "graphql-transport-ws",
+			out:         NameGraphql,
+		},
+		"other subprotocol": {
+			url:         "wss://ws-feed.exchange.coinbase.com",
+			subprotocol: "v12.stomp",
+			out:         NameCoinbase,
+		},
+		"no subprotocol": {
+			url: "wss://example.com/graphql",
+			out: NameGeneric,
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			assert.Equal(t, c.out, lookup(c.name, c.url, c.subprotocol).name)
+		})
+	}
+}
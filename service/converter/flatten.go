@@ -0,0 +1,184 @@
+package converter
+
+import (
+	"fmt"
+	"github.com/cloudevents/sdk-go/binding/format/protobuf/v2/pb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// flattenDepthMax limits the nesting of the values to flatten, the deeper ones are skipped.
+const flattenDepthMax = 5
+
+// flattenAttrsMax limits the count of the attributes flattened per event.
+const flattenAttrsMax = 50
+
+// flattenValueLenMax limits the string attribute value length, the longer values are truncated.
+const flattenValueLenMax = 1024
+
+// flattenHashLen is the length of the path hash suffix of the attribute name shortened to attrNameMaxLen.
+const flattenHashLen = 6
+
+// flattenNameDefault is used when nothing is left of the path after the sanitizing, e.g. for the non-latin keys.
+const flattenNameDefault = "attr"
+
+// flattener sets the attributes for the message values not covered by the schema. The attribute name is derived from
+// the value path: the keys are lowercased, stripped of anything except [a-z0-9] and concatenated, e.g. the
+// "data.last_price" value goes to "datalastprice". The name longer than attrNameMaxLen is shortened and gets the path
+// hash suffix, the taken or reserved name gets the number suffix. The keys are visited in order, so the names are
+// stable for the same message shape.
+type flattener struct {
+	evt   *pb.CloudEvent
+	count int
+}
+
+func flatten(evt *pb.CloudEvent, msg map[string]any, schema map[string]any) {
+	f := flattener{
+		evt: evt,
+	}
+	f.walk(msg, schema, nil)
+}
+
+func (f *flattener) walk(v any, schema map[string]any, path []string) {
+	if f.count >= flattenAttrsMax || len(path) > flattenDepthMax {
+		v = nil
+	}
+	switch vt := v.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(vt))
+		for k := range vt {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			// the values converted by the schema are skipped
+			if _, converted := schema[k].(ConvertFunc); !converted {
+				schemaChild, _ := schema[k].(map[string]any)
+				f.walk(vt[k], schemaChild, append(path, k))
+			}
+		}
+	case []any:
+		var strs []string
+		for i, e := range vt {
+			switch e.(type) {
+			case map[string]any, []any:
+				f.walk(e, nil, append(path, strconv.Itoa(i)))
+			default:
+				if s, err := toString("", e); err == nil {
+					strs = append(strs, s)
+				}
+			}
+		}
+		if len(strs) > 0 {
+			f.set(path, stringAttr(strings.Join(strs, " ")))
+		}
+	default:
+		if attr := inferAttr(v); attr != nil {
+			f.set(path, attr)
+		}
+	}
+}
+
+func (f *flattener) set(path []string, attr *pb.CloudEventAttributeValue) {
+	if f.count < flattenAttrsMax && len(path) > 0 {
+		f.evt.Attributes[f.name(path)] = attr
+		f.count++
+	}
+}
+
+// name returns the free attribute name for the path.
+func (f *flattener) name(path []string) (name string) {
+	var sb strings.Builder
+	for _, k := range path {
+		for _, c := range strings.ToLower(k) {
+			if c >= 'a' && c <= 'z' || c >= '0' && c <= '9' {
+				sb.WriteRune(c)
+			}
+		}
+	}
+	name = sb.String()
+	if name == "" {
+		name = flattenNameDefault
+	}
+	if len(name) > attrNameMaxLen {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(strings.Join(path, ".")))
+		name = fmt.Sprintf("%s%0*x", name[:attrNameMaxLen-flattenHashLen], flattenHashLen, h.Sum32())[:attrNameMaxLen]
+	}
+	base := name
+	for n := 2; f.taken(name); n++ {
+		suffix := strconv.Itoa(n)
+		name = base[:min(len(base), attrNameMaxLen-len(suffix))] + suffix
+	}
+	return
+}
+
+func (f *flattener) taken(name string) (taken bool) {
+	_, taken = f.evt.Attributes[name]
+	return taken || attrsReserved[name]
+}
+
+// inferAttr returns nil for the null or unsupported value.
+func inferAttr(v any) (attr *pb.CloudEventAttributeValue) {
+	switch vt := v.(type) {
+	case bool:
+		attr = &pb.CloudEventAttributeValue{
+			Attr: &pb.CloudEventAttributeValue_CeBoolean{
+				CeBoolean: vt,
+			},
+		}
+	case int, int64, float64:
+		i, ok := toInt32(vt)
+		switch ok {
+		case true:
+			attr = &pb.CloudEventAttributeValue{
+				Attr: &pb.CloudEventAttributeValue_CeInteger{
+					CeInteger: i,
+				},
+			}
+		default:
+			s, _ := toString("", vt)
+			attr = stringAttr(s)
+		}
+	case string:
+		t, err := time.Parse(time.RFC3339, vt)
+		switch err {
+		case nil:
+			attr = &pb.CloudEventAttributeValue{
+				Attr: &pb.CloudEventAttributeValue_CeTimestamp{
+					CeTimestamp: timestamppb.New(t),
+				},
+			}
+		default:
+			attr = stringAttr(vt)
+		}
+	case []byte:
+		attr = &pb.CloudEventAttributeValue{
+			Attr: &pb.CloudEventAttributeValue_CeBytes{
+				CeBytes: vt,
+			},
+		}
+	}
+	return
+}
+
+// stringAttr truncates the value to flattenValueLenMax bytes w/o breaking the last rune.
+func stringAttr(s string) *pb.CloudEventAttributeValue {
+	if len(s) > flattenValueLenMax {
+		end := flattenValueLenMax
+		for end > 0 && !utf8.RuneStart(s[end]) {
+			end--
+		}
+		s = s[:end]
+	}
+	return &pb.CloudEventAttributeValue{
+		Attr: &pb.CloudEventAttributeValue_CeString{
+			CeString: s,
+		},
+	}
+}
@@ -0,0 +1,198 @@
+package converter
+
+import (
+	"fmt"
+	"github.com/cloudevents/sdk-go/binding/format/protobuf/v2/pb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFlatten(t *testing.T) {
+	cases := map[string]struct {
+		msg    map[string]any
+		schema map[string]any
+		attrs  map[string]any
+	}{
+		"types": {
+			msg: map[string]any{
+				"s":    "foo",
+				"i":    int64(42),
+				"big":  int64(1) << 40,
+				"f":    1.5,
+				"fi":   2.0,
+				"b":    true,
+				"t":    "2025-01-01T00:00:00Z",
+				"null": nil,
+				"bin":  []byte{1, 2},
+			},
+			attrs: map[string]any{
+				"s":   "foo",
+				"i":   int32(42),
+				"big": "1099511627776",
+				"f":   "1.500000",
+				"fi":  int32(2),
+				"b":   true,
+				"t":   time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+				"bin": []byte{1, 2},
+			},
+		},
+		"nested names sanitized": {
+			msg: map[string]any{
+				"Data": map[string]any{
+					"last_price": "1.5",
+					"Ünit-Name":  "BTC",
+				},
+			},
+			attrs: map[string]any{
+				"datalastprice": "1.5",
+				"datanitname":   "BTC",
+			},
+		},
+		"arrays": {
+			msg: map[string]any{
+				"tags": []any{"a", "b", int64(1)},
+				"trades": []any{
+					map[string]any{
+						"id": "x",
+					},
+					map[string]any{
+						"id": "y",
+					},
+				},
+			},
+			attrs: map[string]any{
+				"tags":      "a b 1",
+				"trades0id": "x",
+				"trades1id": "y",
+			},
+		},
+		"schema covered keys skipped": {
+			msg: map[string]any{
+				"text": "hello",
+				"data": map[string]any{
+					"mag":  4.2,
+					"unit": "mb",
+				},
+			},
+			schema: map[string]any{
+				"text": toTextDataFunc("text"),
+				"data": map[string]any{
+					"mag": toAttrStringFunc("magnitude"),
+				},
+			},
+			attrs: map[string]any{
+				"dataunit": "mb",
+			},
+		},
+		"reserved and collisions": {
+			msg: map[string]any{
+				"type": "trade",
+				"a_b":  "1",
+				"ab":   "2",
+				"id":   "3",
+			},
+			attrs: map[string]any{
+				"ab":    "1",
+				"ab2":   "2",
+				"id2":   "3",
+				"type2": "trade",
+			},
+		},
+		"non-latin key": {
+			msg: map[string]any{
+				"цена": "1",
+			},
+			attrs: map[string]any{
+				"attr": "1",
+			},
+		},
+		"too deep": {
+			msg: map[string]any{
+				"a": map[string]any{
+					"b": map[string]any{
+						"c": map[string]any{
+							"d": map[string]any{
+								"e": "ok",
+								"f": map[string]any{
+									"g": "too deep",
+								},
+							},
+						},
+					},
+				},
+			},
+			attrs: map[string]any{
+				"abcde": "ok",
+			},
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			evt := &pb.CloudEvent{
+				Attributes: make(map[string]*pb.CloudEventAttributeValue),
+				Data:       &pb.CloudEvent_TextData{},
+			}
+			require.Nil(t, convert(evt, c.msg, c.schema))
+			flatten(evt, c.msg, c.schema)
+			attrs := AttrValues(evt)
+			// set by the schema
+			delete(attrs, "magnitude")
+			assert.Equal(t, c.attrs, attrs)
+		})
+	}
+}
+
+func TestFlatten_Limits(t *testing.T) {
+	msg := map[string]any{
+		"long": strings.Repeat("я", flattenValueLenMax),
+		"veryLongKeyOfTheFeedValue": map[string]any{
+			"nested": "1",
+		},
+	}
+	for i := range 2 * flattenAttrsMax {
+		msg[fmt.Sprintf("k%03d", i)] = "v"
+	}
+	evt := &pb.CloudEvent{
+		Attributes: make(map[string]*pb.CloudEventAttributeValue),
+	}
+	flatten(evt, msg, nil)
+	assert.Len(t, evt.Attributes, flattenAttrsMax)
+	for name := range evt.Attributes {
+		assert.Nil(t, validateAttrName(name))
+	}
+	evt = &pb.CloudEvent{
+		Attributes: make(map[string]*pb.CloudEventAttributeValue),
+	}
+	flatten(evt, map[string]any{
+		"long": msg["long"],
+		"veryLongKeyOfTheFeedValue": map[string]any{
+			"nested": "1",
+		},
+	}, nil)
+	long := evt.Attributes["long"].GetCeString()
+	assert.LessOrEqual(t, len(long), flattenValueLenMax)
+	assert.True(t, strings.HasPrefix(msg["long"].(string), long))
+	require.Len(t, evt.Attributes, 2)
+	for name := range evt.Attributes {
+		assert.Nil(t, validateAttrName(name))
+		assert.LessOrEqual(t, len(name), attrNameMaxLen)
+	}
+}
+
+func TestSvc_Convert_Flatten(t *testing.T) {
+	s := NewService("com_awakari_websocket_v1")
+	evts, skipped, err := s.Convert(Source{Url: "wss://example.com"}, map[string]any{
+		"event": "trade",
+		"data": map[string]any{
+			"price": "1.5",
+		},
+	})
+	require.Nil(t, err)
+	assert.Equal(t, Skipped{}, skipped)
+	require.Len(t, evts, 1)
+	assert.Equal(t, "trade", evts[0].Attributes["event"].GetCeString())
+	assert.Equal(t, "1.5", evts[0].Attributes["dataprice"].GetCeString())
+}
@@ -0,0 +1,19 @@
+package converter
+
+import (
+	"github.com/cloudevents/sdk-go/binding/format/protobuf/v2/pb"
+)
+
+// for details see: https://github.com/enisdenjo/graphql-ws/blob/master/PROTOCOL.md
+
+// convSchemaGraphqlNext skips the operation fields, the payload is flattened to the attributes, e.g. the
+// "payload.data.price" value goes to "payloaddataprice".
+var convSchemaGraphqlNext = map[string]any{
+	"id":   skipFunc,
+	"type": skipFunc,
+}
+
+// skipFunc converts nothing, it excludes the value from the flattening.
+var skipFunc ConvertFunc = func(evt *pb.CloudEvent, v any) (err error) {
+	return
+}
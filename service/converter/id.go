@@ -0,0 +1,46 @@
+package converter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// naturalIdLen is the length of the id hash in bytes, the id is hex encoded.
+const naturalIdLen = 16
+
+// naturalId returns the id derived from the stream URL and the message values at the key paths, so the same message
+// received again, e.g. after a reconnect, gets the same id. The ok is false when there are no keys or any key value
+// is missing or not a scalar.
+func naturalId(url string, keys []string, msg map[string]any) (id string, ok bool) {
+	ok = len(keys) > 0
+	h := sha256.New()
+	h.Write([]byte(url))
+	for i := 0; ok && i < len(keys); i++ {
+		var v any = msg
+		for _, k := range strings.Split(keys[i], ".") {
+			m, mOk := v.(map[string]any)
+			if !mOk {
+				v = nil
+				break
+			}
+			v = m[k]
+		}
+		var s string
+		switch v.(type) {
+		case nil, map[string]any, []any:
+			ok = false
+		default:
+			var err error
+			s, err = toString(keys[i], v)
+			ok = err == nil
+		}
+		// the separator prevents the collisions like "ab"+"c" and "a"+"bc"
+		h.Write([]byte{0})
+		h.Write([]byte(s))
+	}
+	if ok {
+		id = hex.EncodeToString(h.Sum(nil)[:naturalIdLen])
+	}
+	return
+}
@@ -0,0 +1,106 @@
+package converter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestNaturalId(t *testing.T) {
+	keys := []string{
+		"product_id",
+		"x.trade_id",
+	}
+	cases := map[string]struct {
+		keys []string
+		msg  map[string]any
+		ok   bool
+	}{
+		"ok": {
+			keys: keys,
+			msg: map[string]any{
+				"product_id": "BTC-USD",
+				"x": map[string]any{
+					"trade_id": int64(42),
+				},
+			},
+			ok: true,
+		},
+		"no keys": {
+			msg: map[string]any{
+				"product_id": "BTC-USD",
+			},
+		},
+		"missing key": {
+			keys: keys,
+			msg: map[string]any{
+				"product_id": "BTC-USD",
+			},
+		},
+		"not a scalar": {
+			keys: keys,
+			msg: map[string]any{
+				"product_id": "BTC-USD",
+				"x": map[string]any{
+					"trade_id": []any{int64(42)},
+				},
+			},
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			id, ok := naturalId("wss://example.com", c.keys, c.msg)
+			assert.Equal(t, c.ok, ok)
+			assert.Equal(t, c.ok, id != "")
+		})
+	}
+}
+
+func TestNaturalId_Stable(t *testing.T) {
+	keys := []string{
+		"a",
+		"b",
+	}
+	id0, ok := naturalId("wss://example.com", keys, map[string]any{"a": "x", "b": "yz"})
+	require.True(t, ok)
+	id1, _ := naturalId("wss://example.com", keys, map[string]any{"a": "x", "b": "yz", "c": "ignored"})
+	assert.Equal(t, id0, id1)
+	id2, _ := naturalId("wss://example.com", keys, map[string]any{"a": "xy", "b": "z"})
+	assert.NotEqual(t, id0, id2)
+	id3, _ := naturalId("wss://example.org", keys, map[string]any{"a": "x", "b": "yz"})
+	assert.NotEqual(t, id0, id3)
+}
+
+func TestSvc_Convert_NaturalId(t *testing.T) {
+	s := NewService("com_awakari_websocket_v1")
+	src := Source{
+		Url: "wss://ws-feed.exchange.coinbase.com",
+	}
+	trade := func(id int64) map[string]any {
+		return map[string]any{
+			"type":       "ticker",
+			"product_id": "BTC-USD",
+			"trade_id":   id,
+			"price":      "1.5",
+		}
+	}
+	evts0, _, err := s.Convert(src, trade(42))
+	require.Nil(t, err)
+	evts1, _, err := s.Convert(src, trade(42))
+	require.Nil(t, err)
+	evts2, _, err := s.Convert(src, trade(43))
+	require.Nil(t, err)
+	require.Len(t, evts0, 1)
+	require.Len(t, evts1, 1)
+	require.Len(t, evts2, 1)
+	assert.Equal(t, evts0[0].Id, evts1[0].Id)
+	assert.NotEqual(t, evts0[0].Id, evts2[0].Id)
+	// no natural key falls back to the unique id
+	msg := trade(42)
+	delete(msg, "trade_id")
+	evts0, _, err = s.Convert(src, msg)
+	require.Nil(t, err)
+	evts1, _, err = s.Convert(src, msg)
+	require.Nil(t, err)
+	assert.NotEqual(t, evts0[0].Id, evts1[0].Id)
+}
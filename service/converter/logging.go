@@ -18,13 +18,13 @@ func NewLogging(svc Service, log *slog.Logger) Service {
 	}
 }
 
-func (l logging) Convert(src string, raw map[string]any) (evt *pb.CloudEvent, err error) {
-	evt, err = l.svc.Convert(src, raw)
+func (l logging) Convert(src Source, raw map[string]any) (evts []*pb.CloudEvent, skipped Skipped, err error) {
+	evts, skipped, err = l.svc.Convert(src, raw)
 	switch err {
 	case nil:
-		l.log.Debug(fmt.Sprintf("converter.Convert(%s): evt.Id=%s", src, evt.Id))
+		l.log.Debug(fmt.Sprintf("converter.Convert(%+v): %d events, skipped %+v", src, len(evts), skipped))
 	default:
-		l.log.Warn(fmt.Sprintf("converter.Convert(%s, %+v): %s", src, raw, err))
+		l.log.Warn(fmt.Sprintf("converter.Convert(%+v, %+v): %s", src, raw, err))
 	}
 	return
 }
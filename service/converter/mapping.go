@@ -0,0 +1,160 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+	"github.com/awakari/source-websocket/model"
+	"github.com/cloudevents/sdk-go/binding/format/protobuf/v2/pb"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// attrNameMaxLen is recommended by the CloudEvents spec for the best interoperability.
+const attrNameMaxLen = 20
+
+var attrNamePattern = regexp.MustCompile("^[a-z0-9]+$")
+
+// attrsReserved are the event fields not allowed to be set as the attributes.
+var attrsReserved = map[string]bool{
+	"data":        true,
+	"id":          true,
+	"source":      true,
+	"specversion": true,
+	"type":        true,
+}
+
+var ErrMapping = errors.New("invalid mapping")
+
+// Mappings are the compiled mapping rules, see CompileMappings.
+type Mappings struct {
+	schema map[string]any
+	labels []label
+}
+
+// label is the text line appended for the attribute, if set.
+type label struct {
+	attr string
+	text string
+}
+
+// CompileMappings returns nil when there are no mapping rules.
+func CompileMappings(mappings []model.Mapping) (ms *Mappings, err error) {
+	for i, m := range mappings {
+		var f ConvertFunc
+		f, err = compileMapping(m)
+		if err == nil && ms == nil {
+			ms = &Mappings{
+				schema: make(map[string]any),
+			}
+		}
+		if err == nil {
+			err = putMapping(ms.schema, strings.Split(m.Path, "."), f)
+		}
+		if err != nil {
+			ms = nil
+			err = fmt.Errorf("%w #%d: %w", ErrMapping, i, err)
+			break
+		}
+		if m.Label != "" {
+			ms.labels = append(ms.labels, label{
+				attr: m.Attribute,
+				text: m.Label,
+			})
+		}
+	}
+	return
+}
+
+// appendLabels appends the "<label>: <value>" lines to the event text in the order of the mapping rules.
+func (ms *Mappings) appendLabels(evt *pb.CloudEvent) {
+	txt, txtOk := evt.Data.(*pb.CloudEvent_TextData)
+	for i := 0; ms != nil && txtOk && i < len(ms.labels); i++ {
+		l := ms.labels[i]
+		if a, present := evt.Attributes[l.attr]; present {
+			txt.TextData += fmt.Sprintf("%s: %s\n", l.text, attrString(a))
+		}
+	}
+}
+
+func compileMapping(m model.Mapping) (f ConvertFunc, err error) {
+	switch m.Path {
+	case "":
+		err = errors.New("empty path")
+	default:
+		err = validateAttrName(m.Attribute)
+	}
+	if err == nil {
+		switch m.Type {
+		case model.MappingString:
+			f = toAttrStringFunc(m.Attribute)
+		case model.MappingInt:
+			f = toAttrInt32ElseStringFunc(m.Attribute)
+		case model.MappingTimestamp:
+			f = toAttrTimestampFunc(m.Attribute)
+		case model.MappingJoined:
+			f = toAttrStringJoinedFunc(m.Attribute, m.Param)
+		case model.MappingPrefixed:
+			f = toAttrStringWithPrefixFunc(m.Attribute, m.Param)
+		default:
+			err = fmt.Errorf("unknown type %d", m.Type)
+		}
+	}
+	return
+}
+
+func validateAttrName(k string) (err error) {
+	switch {
+	case len(k) > attrNameMaxLen:
+		err = fmt.Errorf("attribute name %q is longer than %d", k, attrNameMaxLen)
+	case !attrNamePattern.MatchString(k):
+		err = fmt.Errorf("attribute name %q should consist of lowercase letters and digits only", k)
+	case attrsReserved[k]:
+		err = fmt.Errorf("attribute name %q is reserved", k)
+	}
+	return
+}
+
+// putMapping sets the func at the path in the schema, the path should not be a prefix of another mapped one.
+func putMapping(schema map[string]any, path []string, f ConvertFunc) (err error) {
+	node := schema
+	for i, k := range path {
+		if k == "" {
+			err = errors.New("path contains an empty key")
+			break
+		}
+		child, present := node[k]
+		switch {
+		case i == len(path)-1 && present:
+			err = fmt.Errorf("path %q is mapped more than once", strings.Join(path, "."))
+		case i == len(path)-1:
+			node[k] = f
+		case !present:
+			branch := make(map[string]any)
+			node[k] = branch
+			node = branch
+		default:
+			branch, branchOk := child.(map[string]any)
+			if !branchOk {
+				err = fmt.Errorf("path %q conflicts with the mapped %q", strings.Join(path, "."), strings.Join(path[:i+1], "."))
+			}
+			node = branch
+		}
+		if err != nil {
+			break
+		}
+	}
+	return
+}
+
+func attrString(a *pb.CloudEventAttributeValue) (s string) {
+	switch at := a.GetAttr().(type) {
+	case *pb.CloudEventAttributeValue_CeString:
+		s = at.CeString
+	case *pb.CloudEventAttributeValue_CeInteger:
+		s = fmt.Sprint(at.CeInteger)
+	case *pb.CloudEventAttributeValue_CeTimestamp:
+		s = at.CeTimestamp.AsTime().Format(time.RFC3339)
+	}
+	return
+}
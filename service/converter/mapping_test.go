@@ -0,0 +1,181 @@
+package converter
+
+import (
+	"github.com/awakari/source-websocket/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestCompileMappings(t *testing.T) {
+	cases := map[string]struct {
+		mappings []model.Mapping
+		err      error
+	}{
+		"none": {},
+		"ok": {
+			mappings: []model.Mapping{
+				{
+					Path:      "data.mag",
+					Attribute: "magnitude",
+				},
+				{
+					Path:      "data.time",
+					Attribute: "time",
+					Type:      model.MappingTimestamp,
+				},
+			},
+		},
+		"empty path": {
+			mappings: []model.Mapping{
+				{
+					Attribute: "magnitude",
+				},
+			},
+			err: ErrMapping,
+		},
+		"empty path key": {
+			mappings: []model.Mapping{
+				{
+					Path:      "data..mag",
+					Attribute: "magnitude",
+				},
+			},
+			err: ErrMapping,
+		},
+		"uppercase attribute": {
+			mappings: []model.Mapping{
+				{
+					Path:      "mag",
+					Attribute: "Magnitude",
+				},
+			},
+			err: ErrMapping,
+		},
+		"too long attribute": {
+			mappings: []model.Mapping{
+				{
+					Path:      "mag",
+					Attribute: "earthquakemagnitudevalue",
+				},
+			},
+			err: ErrMapping,
+		},
+		"reserved attribute": {
+			mappings: []model.Mapping{
+				{
+					Path:      "id",
+					Attribute: "id",
+				},
+			},
+			err: ErrMapping,
+		},
+		"unknown type": {
+			mappings: []model.Mapping{
+				{
+					Path:      "mag",
+					Attribute: "magnitude",
+					Type:      42,
+				},
+			},
+			err: ErrMapping,
+		},
+		"duplicate path": {
+			mappings: []model.Mapping{
+				{
+					Path:      "mag",
+					Attribute: "magnitude",
+				},
+				{
+					Path:      "mag",
+					Attribute: "mag",
+				},
+			},
+			err: ErrMapping,
+		},
+		"path conflict": {
+			mappings: []model.Mapping{
+				{
+					Path:      "data",
+					Attribute: "data0",
+				},
+				{
+					Path:      "data.mag",
+					Attribute: "magnitude",
+				},
+			},
+			err: ErrMapping,
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			_, err := CompileMappings(c.mappings)
+			assert.ErrorIs(t, err, c.err)
+		})
+	}
+}
+
+func TestSvc_Convert_Mappings(t *testing.T) {
+	mappings, err := CompileMappings([]model.Mapping{
+		{
+			Path:      "data.mag",
+			Attribute: "magnitude",
+			Type:      model.MappingInt,
+			Label:     "Magnitude",
+		},
+		{
+			Path:      "data.time",
+			Attribute: "time",
+			Type:      model.MappingTimestamp,
+		},
+		{
+			Path:      "data.tags",
+			Attribute: "tags",
+			Type:      model.MappingJoined,
+			Param:     ",",
+		},
+		{
+			Path:      "data.id",
+			Attribute: "objecturl",
+			Type:      model.MappingPrefixed,
+			Param:     "https://example.com/",
+			Label:     "Details",
+		},
+		{
+			Path:      "text",
+			Attribute: "note",
+		},
+	})
+	require.Nil(t, err)
+	s := NewService("com_awakari_websocket_v1")
+	src := Source{
+		Url:      "wss://example.com",
+		Mappings: mappings,
+	}
+	evts, _, err := s.Convert(src, map[string]any{
+		"data": map[string]any{
+			"mag":  int64(5),
+			"time": int64(1735689600),
+			"tags": []any{"a", "b"},
+			"id":   "42",
+		},
+	})
+	require.Nil(t, err)
+	require.Len(t, evts, 1)
+	attrs := evts[0].Attributes
+	assert.Equal(t, int32(5), attrs["magnitude"].GetCeInteger())
+	assert.Equal(t, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), attrs["time"].GetCeTimestamp().AsTime())
+	assert.Equal(t, "a,b", attrs["tags"].GetCeString())
+	assert.Equal(t, "https://example.com/42", attrs["objecturl"].GetCeString())
+	assert.Contains(t, evts[0].GetTextData(), "Magnitude: 5\n")
+	assert.Contains(t, evts[0].GetTextData(), "Details: https://example.com/42\n")
+	// the mapping takes precedence over the converter's schema
+	evts, _, err = s.Convert(src, map[string]any{
+		"text": "hello",
+	})
+	require.Nil(t, err)
+	require.Len(t, evts, 1)
+	assert.Equal(t, "hello", evts[0].Attributes["note"].GetCeString())
+	assert.Equal(t, "", evts[0].GetTextData())
+}
@@ -0,0 +1,193 @@
+package converter
+
+import (
+	"net/url"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+// Names of the registered converters, see registry.
+const (
+	NameGeneric       = "generic"
+	NameSeismicportal = "seismicportal"
+	NameCoinbase      = "coinbase"
+	NameBlockchain    = "blockchain"
+	NameGraphql       = "graphql"
+)
+
+// entry is the converter dedicated to the particular feed.
+type entry struct {
+	name string
+	// patterns select the entry by the stream URL, the pattern is the host suffix optionally followed by the path
+	// prefix, e.g. "coinbase.com" or "blockchain.info/inv".
+	patterns []string
+	// subprotocols select the entry by the websocket subprotocol negotiated with the server, the URL should match the
+	// patterns too if any.
+	subprotocols []string
+	// discriminators route the message to the dedicated schema, see route.
+	discriminators []discriminator
+	// fallback is the schema used when the message has none of the discriminator keys, see route.
+	fallback map[string]any
+	// text is the default template of the event text, nil means the text is set by the schema only.
+	text *template.Template
+	// flatten enables converting the values not covered by the schema to the attributes, see flattener.
+	flatten bool
+	// keys are the dot separated paths of the message values identifying the event, see naturalId.
+	keys []string
+}
+
+// registry is tried in order, the first entry matching the stream URL and the negotiated subprotocol is selected, so
+// the entries selected by the subprotocol go first. The generic one has neither patterns nor subprotocols and is
+// selected when nothing else matches, it flattens the whole message to the attributes.
+var registry = []entry{
+	{
+		// https://github.com/enisdenjo/graphql-ws/blob/master/PROTOCOL.md
+		name: NameGraphql,
+		subprotocols: []string{
+			"graphql-transport-ws",
+			// the legacy subscriptions-transport-ws protocol
+			"graphql-ws",
+		},
+		discriminators: []discriminator{
+			{
+				key: "type",
+				routes: map[string]map[string]any{
+					"next":           convSchemaGraphqlNext,
+					"data":           convSchemaGraphqlNext,
+					"connection_ack": convSchemaControl,
+					"ping":           convSchemaControl,
+					"pong":           convSchemaControl,
+					"ka":             convSchemaControl,
+					"complete":       convSchemaControl,
+					"error":          convSchemaControl,
+				},
+			},
+		},
+		fallback: convSchemaGeneric,
+		flatten:  true,
+	},
+	{
+		// https://www.seismicportal.eu/realtime.html
+		name: NameSeismicportal,
+		patterns: []string{
+			"seismicportal.eu/standing_order",
+		},
+		discriminators: []discriminator{
+			{
+				key: "action",
+				routes: map[string]map[string]any{
+					"create": convSchemaSeismicportal,
+					"update": convSchemaSeismicportal,
+				},
+			},
+		},
+		fallback: mergeSchemas(convSchemaGeneric, convSchemaSeismicportal),
+		text:     textSeismicportal,
+		// the event is updated several times
+		keys: []string{
+			"action",
+			"data.properties.unid",
+			"data.properties.lastupdate",
+		},
+	},
+	{
+		// https://docs.cdp.coinbase.com/exchange/docs/websocket-overview
+		name: NameCoinbase,
+		patterns: []string{
+			"coinbase.com",
+		},
+		discriminators: []discriminator{
+			{
+				key: "type",
+				routes: map[string]map[string]any{
+					"ticker":        convSchemaCoinbaseTicker,
+					"subscriptions": convSchemaControl,
+					"heartbeat":     convSchemaControl,
+					"error":         convSchemaControl,
+				},
+			},
+		},
+		fallback: mergeSchemas(convSchemaGeneric, convSchemaCoinbaseTicker),
+		text:     textCoinbaseTicker,
+		keys: []string{
+			"product_id",
+			"trade_id",
+		},
+	},
+	{
+		// https://www.blockchain.com/explorer/api/api_websocket
+		name: NameBlockchain,
+		patterns: []string{
+			"blockchain.info/inv",
+			"blockchain.com",
+		},
+		discriminators: []discriminator{
+			{
+				key: "op",
+				routes: map[string]map[string]any{
+					"block": convSchemaBlockchainBlock,
+					"pong":  convSchemaControl,
+				},
+			},
+		},
+		fallback: mergeSchemas(convSchemaGeneric, convSchemaBlockchainBlock),
+		text:     textBlockchainBlock,
+		keys: []string{
+			"x.hash",
+		},
+	},
+	{
+		name:     NameGeneric,
+		fallback: convSchemaGeneric,
+		flatten:  true,
+	},
+}
+
+// Supported returns true when the converter name is registered, the empty name means the selection by the URL.
+func Supported(name string) (ok bool) {
+	ok = name == ""
+	for i := 0; !ok && i < len(registry); i++ {
+		ok = registry[i].name == name
+	}
+	return
+}
+
+// lookup returns the entry by the name when set, otherwise selects it by the stream URL and the subprotocol.
+func lookup(name, u, subprotocol string) (e entry) {
+	e = registry[len(registry)-1]
+	for _, candidate := range registry {
+		if name == "" && candidate.matches(u, subprotocol) || name != "" && candidate.name == name {
+			e = candidate
+			break
+		}
+	}
+	return
+}
+
+func (e entry) matches(u, subprotocol string) (ok bool) {
+	switch {
+	case len(e.subprotocols) > 0:
+		ok = slices.Contains(e.subprotocols, subprotocol) && (len(e.patterns) == 0 || e.matchesUrl(u))
+	default:
+		ok = e.matchesUrl(u)
+	}
+	return
+}
+
+func (e entry) matchesUrl(u string) (ok bool) {
+	parsed, err := url.Parse(u)
+	if err == nil {
+		host := strings.ToLower(parsed.Hostname())
+		for _, p := range e.patterns {
+			pHost, pPath, _ := strings.Cut(p, "/")
+			if host == pHost || strings.HasSuffix(host, "."+pHost) {
+				ok = strings.HasPrefix(strings.TrimPrefix(parsed.Path, "/"), pPath)
+			}
+			if ok {
+				break
+			}
+		}
+	}
+	return
+}
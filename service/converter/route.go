@@ -0,0 +1,48 @@
+package converter
+
+import "fmt"
+
+// discriminator selects the schema by the value of the message key, e.g. "type": "ticker".
+type discriminator struct {
+	key    string
+	routes map[string]map[string]any
+}
+
+// route returns the schema for the message by the first discriminator routing it. The fallback is returned only when
+// the message has none of the discriminator keys. When the key is present but its value is not routed, the message
+// is of the type not known to the feed, so the generic schema is returned instead of the fallback one: otherwise the
+// feed schema would convert the fields of the same names but of another meaning.
+func route(msg map[string]any, discriminators []discriminator, fallback map[string]any) (schema map[string]any) {
+	schema = fallback
+	for _, d := range discriminators {
+		v, present := msg[d.key]
+		if !present {
+			continue
+		}
+		var routed bool
+		schema, routed = d.routes[fmt.Sprint(v)]
+		if routed {
+			break
+		}
+		schema = convSchemaGeneric
+	}
+	return
+}
+
+// mergeSchemas returns the union of the schemas, the nested schemas are merged too.
+func mergeSchemas(schemas ...map[string]any) (merged map[string]any) {
+	merged = make(map[string]any)
+	for _, schema := range schemas {
+		for k, v := range schema {
+			vm, vmOk := v.(map[string]any)
+			mergedM, mergedMOk := merged[k].(map[string]any)
+			switch {
+			case vmOk && mergedMOk:
+				merged[k] = mergeSchemas(mergedM, vm)
+			default:
+				merged[k] = v
+			}
+		}
+	}
+	return
+}
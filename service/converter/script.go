@@ -0,0 +1,308 @@
+package converter
+
+import (
+	"errors"
+	"fmt"
+	"github.com/cloudevents/sdk-go/binding/format/protobuf/v2/pb"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+	"math"
+	"reflect"
+	"sort"
+)
+
+// Script is the compiled Starlark script defining the function "transform(msg)". The function receives the decoded
+// message as a dict and returns None, a dict or a list of dicts. Every dict is the event: "attrs" is the dict of the
+// string, int, float or bool attribute values and "text" is the event text. Returning None or an empty list means
+// the message is a control one.
+type Script struct {
+	fn     *starlark.Function
+	limits ScriptLimits
+}
+
+// ScriptLimits bounds the script execution per message.
+type ScriptLimits struct {
+	// Steps is the max count of the abstract Starlark computation steps.
+	Steps uint64
+	// Memory is the max count of the bytes allocated for the new values by the operators, slices, methods and builtins,
+	// e.g. "s * n", "l[:]" or "s.upper()". The size is estimated before the call, so the script is aborted before the
+	// allocation.
+	Memory int
+	// Input is the max count of the message values, including the nested ones, to pass to the script.
+	Input int
+	// Events is the max count of the events returned for a message.
+	Events int
+	// Attrs is the max count of the attributes per event.
+	Attrs int
+	// StringLen is the max length of the event text and of every string attribute value.
+	StringLen int
+}
+
+const scriptFuncName = "transform"
+const scriptKeyAttrs = "attrs"
+const scriptKeyText = "text"
+
+var ErrScript = errors.New("invalid script")
+
+// CompileScript returns nil when the source is empty. The top-level statements are executed once within the limits.
+func CompileScript(src string, limits ScriptLimits) (s *Script, err error) {
+	if src != "" {
+		// the default options disallow the while loops, recursion and the top-level control statements
+		opts := &syntax.FileOptions{}
+		var f *syntax.File
+		f, err = opts.Parse("script.star", src, 0)
+		var prog *starlark.Program
+		if err == nil {
+			boundAllocs(f)
+			prog, err = starlark.FileProgram(f, scriptPredeclared.Has)
+		}
+		var globals starlark.StringDict
+		if err == nil {
+			err = run(limits, func(thread *starlark.Thread) (err error) {
+				globals, err = prog.Init(thread, scriptPredeclared)
+				return
+			})
+		}
+		var fn *starlark.Function
+		if err == nil {
+			var fnOk bool
+			fn, fnOk = globals[scriptFuncName].(*starlark.Function)
+			switch {
+			case !fnOk:
+				err = fmt.Errorf("function %q is not defined", scriptFuncName)
+			case fn.NumParams() != 1:
+				err = fmt.Errorf("function %q should take exactly 1 parameter, got %d", scriptFuncName, fn.NumParams())
+			}
+		}
+		switch err {
+		case nil:
+			// frozen values are safe to share between the threads
+			globals.Freeze()
+			s = &Script{
+				fn:     fn,
+				limits: limits,
+			}
+		default:
+			err = fmt.Errorf("%w: %s", ErrScript, err)
+		}
+	}
+	return
+}
+
+// run executes f in the new thread within the steps and memory limits.
+func run(limits ScriptLimits, f func(thread *starlark.Thread) error) (err error) {
+	thread := &starlark.Thread{
+		Name: "script",
+	}
+	thread.SetMaxExecutionSteps(limits.Steps)
+	thread.SetLocal(scriptLocalAlloc, &allocBudget{
+		limit: limits.Memory,
+		left:  limits.Memory,
+	})
+	err = f(thread)
+	return
+}
+
+// transform calls the script function for the message, every dict returned is applied to the new event.
+func (s *Script) transform(msg map[string]any, newEvent func() *pb.CloudEvent) (evts []*pb.CloudEvent, err error) {
+	var out starlark.Value
+	err = run(s.limits, func(thread *starlark.Thread) (err error) {
+		var in starlark.Value
+		budget := s.limits.Input
+		in, err = toStarlark(msg, &budget)
+		if err == nil {
+			out, err = starlark.Call(thread, s.fn, starlark.Tuple{in}, nil)
+		}
+		return
+	})
+	var outs []starlark.Value
+	if err == nil {
+		switch ot := out.(type) {
+		case starlark.NoneType:
+		case *starlark.Dict:
+			outs = append(outs, ot)
+		case *starlark.List:
+			for i := 0; i < ot.Len(); i++ {
+				outs = append(outs, ot.Index(i))
+			}
+		default:
+			err = fmt.Errorf("returned %s, expected None, dict or list", out.Type())
+		}
+	}
+	if err == nil && len(outs) > s.limits.Events {
+		err = fmt.Errorf("returned %d events, limit is %d", len(outs), s.limits.Events)
+	}
+	for i := 0; i < len(outs) && err == nil; i++ {
+		evt := newEvent()
+		err = applyScriptEvent(evt, outs[i], s.limits)
+		if err == nil {
+			evts = append(evts, evt)
+		}
+	}
+	if err != nil {
+		evts = nil
+		err = fmt.Errorf("%w: script: %s", ErrConversion, err)
+	}
+	return
+}
+
+func applyScriptEvent(evt *pb.CloudEvent, v starlark.Value, limits ScriptLimits) (err error) {
+	d, dOk := v.(*starlark.Dict)
+	if !dOk {
+		err = fmt.Errorf("event is %s, expected dict", v.Type())
+	}
+	if err == nil {
+		for _, item := range d.Items() {
+			k, _ := starlark.AsString(item[0])
+			switch k {
+			case scriptKeyAttrs:
+				err = applyScriptAttrs(evt, item[1], limits)
+			case scriptKeyText:
+				txt, txtOk := starlark.AsString(item[1])
+				switch {
+				case !txtOk:
+					err = fmt.Errorf("event text is %s, expected string", item[1].Type())
+				case len(txt) > limits.StringLen:
+					err = fmt.Errorf("event text length is %d, limit is %d", len(txt), limits.StringLen)
+				default:
+					evt.Data.(*pb.CloudEvent_TextData).TextData = txt
+				}
+			default:
+				err = fmt.Errorf("unexpected event key %s", item[0])
+			}
+			if err != nil {
+				break
+			}
+		}
+	}
+	return
+}
+
+func applyScriptAttrs(evt *pb.CloudEvent, v starlark.Value, limits ScriptLimits) (err error) {
+	d, dOk := v.(*starlark.Dict)
+	switch {
+	case !dOk:
+		err = fmt.Errorf("event attrs is %s, expected dict", v.Type())
+	case d.Len() > limits.Attrs:
+		err = fmt.Errorf("event has %d attributes, limit is %d", d.Len(), limits.Attrs)
+	}
+	if err == nil {
+		for _, item := range d.Items() {
+			k, kOk := starlark.AsString(item[0])
+			switch kOk {
+			case true:
+				err = validateAttrName(k)
+			default:
+				err = fmt.Errorf("attribute name is %s, expected string", item[0].Type())
+			}
+			var attr *pb.CloudEventAttributeValue
+			if err == nil {
+				attr, err = toAttr(item[1])
+			}
+			if err == nil && len(attr.GetCeString()) > limits.StringLen {
+				err = fmt.Errorf("value length is %d, limit is %d", len(attr.GetCeString()), limits.StringLen)
+			}
+			if err != nil {
+				err = fmt.Errorf("attribute %s: %w", item[0], err)
+				break
+			}
+			evt.Attributes[k] = attr
+		}
+	}
+	return
+}
+
+func toAttr(v starlark.Value) (attr *pb.CloudEventAttributeValue, err error) {
+	switch vt := v.(type) {
+	case starlark.Bool:
+		attr = &pb.CloudEventAttributeValue{
+			Attr: &pb.CloudEventAttributeValue_CeBoolean{
+				CeBoolean: bool(vt),
+			},
+		}
+	case starlark.Int:
+		i, iOk := vt.Int64()
+		switch {
+		case iOk && i >= math.MinInt32 && i <= math.MaxInt32:
+			attr = &pb.CloudEventAttributeValue{
+				Attr: &pb.CloudEventAttributeValue_CeInteger{
+					CeInteger: int32(i),
+				},
+			}
+		default:
+			attr = &pb.CloudEventAttributeValue{
+				Attr: &pb.CloudEventAttributeValue_CeString{
+					CeString: vt.String(),
+				},
+			}
+		}
+	case starlark.Float:
+		var s string
+		s, err = toString("", float64(vt))
+		attr = &pb.CloudEventAttributeValue{
+			Attr: &pb.CloudEventAttributeValue_CeString{
+				CeString: s,
+			},
+		}
+	case starlark.String:
+		attr = &pb.CloudEventAttributeValue{
+			Attr: &pb.CloudEventAttributeValue_CeString{
+				CeString: string(vt),
+			},
+		}
+	default:
+		err = fmt.Errorf("value is %s, expected string, int, float or bool", v.Type())
+	}
+	return
+}
+
+// toStarlark converts the decoded message value, the map keys are sorted to keep the iteration order stable. The
+// budget is the count of the values left to convert.
+func toStarlark(v any, budget *int) (sv starlark.Value, err error) {
+	*budget--
+	if *budget < 0 {
+		err = errors.New("message has too many values")
+	}
+	if err == nil {
+		switch vt := v.(type) {
+		case nil:
+			sv = starlark.None
+		case bool:
+			sv = starlark.Bool(vt)
+		case int64:
+			sv = starlark.MakeInt64(vt)
+		case int:
+			sv = starlark.MakeInt(vt)
+		case float64:
+			sv = starlark.Float(vt)
+		case string:
+			sv = starlark.String(vt)
+		case []byte:
+			sv = starlark.Bytes(vt)
+		case []any:
+			elems := make([]starlark.Value, len(vt))
+			for i := 0; i < len(vt) && err == nil; i++ {
+				elems[i], err = toStarlark(vt[i], budget)
+			}
+			sv = starlark.NewList(elems)
+		case map[string]any:
+			keys := make([]string, 0, len(vt))
+			for k := range vt {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			d := starlark.NewDict(len(vt))
+			for i := 0; i < len(keys) && err == nil; i++ {
+				var e starlark.Value
+				e, err = toStarlark(vt[keys[i]], budget)
+				if err == nil {
+					err = d.SetKey(starlark.String(keys[i]), e)
+				}
+			}
+			sv = d
+		default:
+			err = fmt.Errorf("unsupported message value type %s", reflect.TypeOf(v))
+		}
+	}
+	return
+}
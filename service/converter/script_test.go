@@ -0,0 +1,440 @@
+package converter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+var scriptLimitsTest = ScriptLimits{
+	Steps:     100_000,
+	Memory:    16 << 20,
+	Input:     10_000,
+	Events:    100,
+	Attrs:     100,
+	StringLen: 64 << 10,
+}
+
+func TestCompileScript(t *testing.T) {
+	cases := map[string]struct {
+		src string
+		nil bool
+		err error
+	}{
+		"empty": {
+			nil: true,
+		},
+		"ok": {
+			src: `
+def transform(msg):
+    return None
+`,
+		},
+		"syntax error": {
+			src: `
+def transform(msg)
+    return None
+`,
+			err: ErrScript,
+		},
+		"no function": {
+			src: `transform = 1`,
+			err: ErrScript,
+		},
+		"wrong params": {
+			src: `
+def transform(msg, extra):
+    return None
+`,
+			err: ErrScript,
+		},
+		"while loop": {
+			src: `
+def transform(msg):
+    while True:
+        pass
+`,
+			err: ErrScript,
+		},
+		"endless top-level": {
+			src: `
+def spin():
+    for i in range(1000000000):
+        pass
+
+x = spin()
+
+def transform(msg):
+    return None
+`,
+			err: ErrScript,
+		},
+		"too large top-level repetition": {
+			src: `
+x = "a" * 1000000000
+
+def transform(msg):
+    return None
+`,
+			err: ErrScript,
+		},
+		"too large top-level concatenation": {
+			src: `
+def grow():
+    s = "a" * 1024
+    for i in range(20):
+        s = s + s
+    return s
+
+x = grow()
+
+def transform(msg):
+    return None
+`,
+			err: ErrScript,
+		},
+		"too large top-level method results": {
+			src: `
+s = "a" * 1000000
+l = [s.upper() for i in range(2000)]
+
+def transform(msg):
+    return None
+`,
+			err: ErrScript,
+		},
+		"undefined name": {
+			src: `
+def transform(msg):
+    return foo
+`,
+			err: ErrScript,
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			s, err := CompileScript(c.src, scriptLimitsTest)
+			assert.ErrorIs(t, err, c.err)
+			if c.err == nil {
+				assert.Equal(t, c.nil, s == nil)
+			}
+		})
+	}
+}
+
+func TestSvc_Convert_Script(t *testing.T) {
+	s := NewService("com_awakari_websocket_v1")
+	cases := map[string]struct {
+		src     string
+		raw     map[string]any
+		texts   []string
+		attrs   map[string]any
+		skipped Skipped
+		err     error
+	}{
+		"single event": {
+			src: `
+def transform(msg):
+    d = msg["data"]
+    return {
+        "attrs": {"magnitude": str(d["mag"]), "depth": d["depth"], "tsunami": d["tsunami"]},
+        "text": "Earthquake M%s" % d["mag"],
+    }
+`,
+			raw: map[string]any{
+				"data": map[string]any{
+					"mag":     5.2,
+					"depth":   int64(10),
+					"tsunami": false,
+				},
+			},
+			texts: []string{
+				"Earthquake M5.2",
+			},
+			attrs: map[string]any{
+				"magnitude": "5.2",
+				"depth":     int32(10),
+				"tsunami":   false,
+			},
+		},
+		"fan out": {
+			src: `
+def transform(msg):
+    return [{"text": t["id"]} for t in msg["trades"] if t["size"] > 1]
+`,
+			raw: map[string]any{
+				"trades": []any{
+					map[string]any{
+						"id":   "a",
+						"size": int64(2),
+					},
+					map[string]any{
+						"id":   "b",
+						"size": int64(1),
+					},
+					map[string]any{
+						"id":   "c",
+						"size": 1.5,
+					},
+				},
+			},
+			texts: []string{
+				"a",
+				"c",
+			},
+		},
+		"none is control": {
+			src: `
+def transform(msg):
+    return None
+`,
+			raw: map[string]any{
+				"type": "heartbeat",
+			},
+			skipped: Skipped{
+				Control: 1,
+			},
+		},
+		"empty event is unknown": {
+			src: `
+def transform(msg):
+    return {}
+`,
+			raw: map[string]any{},
+			skipped: Skipped{
+				Unknown: 1,
+			},
+		},
+		"runtime error": {
+			src: `
+def transform(msg):
+    return {"text": msg["missing"]}
+`,
+			raw: map[string]any{},
+			err: ErrConversion,
+		},
+		"invalid attribute name": {
+			src: `
+def transform(msg):
+    return {"attrs": {"Magnitude": 5}}
+`,
+			raw: map[string]any{},
+			err: ErrConversion,
+		},
+		"unexpected key": {
+			src: `
+def transform(msg):
+    return {"data": "x"}
+`,
+			raw: map[string]any{},
+			err: ErrConversion,
+		},
+		"too many steps": {
+			src: `
+def transform(msg):
+    n = 0
+    for i in range(1000000000):
+        n += i
+    return None
+`,
+			raw: map[string]any{},
+			err: ErrConversion,
+		},
+		"too large repetition": {
+			src: `
+def transform(msg):
+    l = [0] * 1000000000
+    return None
+`,
+			raw: map[string]any{},
+			err: ErrConversion,
+		},
+		"too large concatenation": {
+			src: `
+def transform(msg):
+    l = [0] * 1024
+    for i in range(20):
+        l += l
+    return None
+`,
+			raw: map[string]any{},
+			err: ErrConversion,
+		},
+		"too large item concatenation": {
+			src: `
+def transform(msg):
+    d = {"s": "a" * 1024}
+    for i in range(20):
+        d["s"] += d["s"]
+    return None
+`,
+			raw: map[string]any{},
+			err: ErrConversion,
+		},
+		"too large join": {
+			src: `
+def transform(msg):
+    s = ("x" * 1024).join(["a"] * 100000)
+    return None
+`,
+			raw: map[string]any{},
+			err: ErrConversion,
+		},
+		"too large getattr join": {
+			src: `
+def transform(msg):
+    s = getattr("x" * 1024, "join")(["a"] * 100000)
+    return None
+`,
+			raw: map[string]any{},
+			err: ErrConversion,
+		},
+		"too large method results": {
+			src: `
+def transform(msg):
+    s = "a" * 1000000
+    l = []
+    for i in range(3000):
+        l.append(s.upper())
+    return None
+`,
+			raw: map[string]any{},
+			err: ErrConversion,
+		},
+		"too large split results": {
+			src: `
+def transform(msg):
+    s = "a," * 500000
+    l = [s.split(",") for i in range(100)]
+    return None
+`,
+			raw: map[string]any{},
+			err: ErrConversion,
+		},
+		"too large slice copies": {
+			src: `
+def transform(msg):
+    l = [0] * 100000
+    acc = [l[:] for i in range(2000)]
+    return None
+`,
+			raw: map[string]any{},
+			err: ErrConversion,
+		},
+		"too large int": {
+			src: `
+def transform(msg):
+    x = 1
+    for i in range(1000):
+        x = x << 500
+    return None
+`,
+			raw: map[string]any{},
+			err: ErrConversion,
+		},
+		"free methods": {
+			src: `
+def transform(msg):
+    s = "a" * 1000000
+    n = 0
+    for i in range(3000):
+        if s.startswith("a") and s.strip().endswith("a"):
+            n += 1
+    return {"text": str(n)}
+`,
+			raw: map[string]any{},
+			texts: []string{
+				"3000",
+			},
+		},
+		"in place concatenation": {
+			src: `
+def transform(msg):
+    l = [1]
+    l2 = l
+    l += [2]
+    d = {"k": [1]}
+    d["k"] += [2]
+    return {"text": ", ".join([str(l2), str(d["k"])])}
+`,
+			raw: map[string]any{},
+			texts: []string{
+				"[1, 2], [1, 2]",
+			},
+		},
+		"too long text": {
+			src: `
+def transform(msg):
+    return {"text": "x" * (64 * 1024 + 1)}
+`,
+			raw: map[string]any{},
+			err: ErrConversion,
+		},
+		"too long attribute": {
+			src: `
+def transform(msg):
+    return {"attrs": {"name": "x" * (64 * 1024 + 1)}}
+`,
+			raw: map[string]any{},
+			err: ErrConversion,
+		},
+		"too many attributes": {
+			src: `
+def transform(msg):
+    return {"attrs": {"a%d" % i: i for i in range(101)}}
+`,
+			raw: map[string]any{},
+			err: ErrConversion,
+		},
+		"too many events": {
+			src: `
+def transform(msg):
+    return [{"text": str(i)} for i in range(101)]
+`,
+			raw: map[string]any{},
+			err: ErrConversion,
+		},
+		"too large input": {
+			src: `
+def transform(msg):
+    return {"text": "x"}
+`,
+			raw: map[string]any{
+				"items": make([]any, 10_000),
+			},
+			err: ErrConversion,
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			script, err := CompileScript(c.src, scriptLimitsTest)
+			require.Nil(t, err)
+			src := Source{
+				Url:    "wss://example.com",
+				Script: script,
+			}
+			evts, skipped, err := s.Convert(src, c.raw)
+			assert.ErrorIs(t, err, c.err)
+			assert.Equal(t, c.skipped, skipped)
+			require.Len(t, evts, len(c.texts))
+			ids := map[string]bool{}
+			for i, evt := range evts {
+				assert.Equal(t, c.texts[i], evt.GetTextData())
+				ids[evt.Id] = true
+			}
+			assert.Len(t, ids, len(evts))
+			for k, v := range c.attrs {
+				attr := evts[0].Attributes[k]
+				require.NotNil(t, attr, k)
+				switch vt := v.(type) {
+				case string:
+					assert.Equal(t, vt, attr.GetCeString())
+				case int32:
+					assert.Equal(t, vt, attr.GetCeInteger())
+				case bool:
+					assert.Equal(t, vt, attr.GetCeBoolean())
+				}
+			}
+		})
+	}
+}
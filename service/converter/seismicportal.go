@@ -1,12 +1,35 @@
 package converter
 
 import (
-	"fmt"
 	"github.com/cloudevents/sdk-go/binding/format/protobuf/v2/pb"
 )
 
 const seismicportalEuEventDetailsHtmlUnid = "https://www.seismicportal.eu/eventdetails.html?unid="
 
+var textSeismicportal = mustTemplate("seismicportal", `Earthquake
+{{if has .attrs "magnitude"}}Magnitude: {{.attrs.magnitude}}
+{{end}}{{if has .attrs "location"}}Location: {{.attrs.location}}
+{{end}}`)
+
+var convSchemaSeismicportal = map[string]any{
+	"action": toAttrStringFunc("action"),
+	"data": map[string]any{
+		"properties": map[string]any{
+			"auth":          toAttrStringFunc("subject"),
+			"depth":         toAttrStringWithPrefixFunc("elevation", "-"),
+			"flynn_region":  convertEarthquakeLocationFunc("location"),
+			"lat":           toAttrStringFunc("latitude"),
+			"lon":           toAttrStringFunc("longitude"),
+			"mag":           convertEarthquakeMagnitudeFunc("magnitude"),
+			"magtype":       toAttrStringFunc("magnitudetype"),
+			"sourcecatalog": toAttrStringFunc("sourcecatalog"),
+			"sourceid":      toAttrStringFunc("sourceid"),
+			"time":          toAttrTimestampFunc("time"),
+			"unid":          toAttrStringWithPrefixFunc("objecturl", seismicportalEuEventDetailsHtmlUnid),
+		},
+	},
+}
+
 func convertEarthquakeLocationFunc(k string) ConvertFunc {
 	return func(evt *pb.CloudEvent, v any) (err error) {
 		var l string
@@ -17,14 +40,6 @@ func convertEarthquakeLocationFunc(k string) ConvertFunc {
 					CeString: l,
 				},
 			}
-			txt := evt.Data.(*pb.CloudEvent_TextData).TextData
-			switch txt {
-			case "":
-				txt = fmt.Sprintf("Earthquake\nLocation: %s\n", l)
-			default:
-				txt += fmt.Sprintf("Location: %s\n", l)
-			}
-			evt.Data.(*pb.CloudEvent_TextData).TextData = txt
 		}
 		return
 	}
@@ -33,18 +48,9 @@ func convertEarthquakeLocationFunc(k string) ConvertFunc {
 func convertEarthquakeMagnitudeFunc(k string) ConvertFunc {
 	attrSetFunc := toAttrInt32ElseStringFunc(k)
 	return func(evt *pb.CloudEvent, v any) (err error) {
-		var m string
-		m, err = toString(k, v)
+		_, err = toString(k, v)
 		if err == nil {
 			err = attrSetFunc(evt, v)
-			txt := evt.Data.(*pb.CloudEvent_TextData).TextData
-			switch txt {
-			case "":
-				txt = fmt.Sprintf("Earthquake\nMagnitude: %s\n", m)
-			default:
-				txt += fmt.Sprintf("Magnitude: %s\n", m)
-			}
-			evt.Data.(*pb.CloudEvent_TextData).TextData = txt
 		}
 		return
 	}
@@ -0,0 +1,98 @@
+package converter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/cloudevents/sdk-go/binding/format/protobuf/v2/pb"
+	"strings"
+	"text/template"
+)
+
+// templateKeyMsg and templateKeyAttrs are the keys of the template data: the decoded message and the event attribute
+// values, e.g. {{.msg.data.id}} or {{if has .attrs "magnitude"}}Magnitude: {{.attrs.magnitude}}{{end}}.
+const templateKeyMsg = "msg"
+const templateKeyAttrs = "attrs"
+
+// TemplateFuncs are shared by the event text and the reply templates. Use "has" instead of "with" to check the value
+// presence, the latter skips the zero values like 0 or false.
+var TemplateFuncs = template.FuncMap{
+	"has": func(m map[string]any, k string) (ok bool) {
+		_, ok = m[k]
+		return
+	},
+	"json": func(v any) (s string, err error) {
+		var data []byte
+		data, err = json.Marshal(v)
+		s = string(data)
+		return
+	},
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+var ErrTemplate = errors.New("invalid template")
+
+// CompileTemplate returns nil when the source is empty.
+func CompileTemplate(src string) (tmpl *template.Template, err error) {
+	if src != "" {
+		tmpl, err = newTemplate("text", src)
+		if err != nil {
+			err = fmt.Errorf("%w: %s", ErrTemplate, err)
+		}
+	}
+	return
+}
+
+func newTemplate(name, src string) (*template.Template, error) {
+	return template.New(name).Funcs(TemplateFuncs).Parse(src)
+}
+
+// mustTemplate is used for the built-in templates only.
+func mustTemplate(name, src string) *template.Template {
+	return template.Must(newTemplate(name, src))
+}
+
+// render replaces the event text by the template output, the binary data is kept as is.
+func render(tmpl *template.Template, evt *pb.CloudEvent, msg map[string]any) (err error) {
+	txt, txtOk := evt.Data.(*pb.CloudEvent_TextData)
+	if tmpl != nil && txtOk {
+		var buf bytes.Buffer
+		err = tmpl.Execute(&buf, map[string]any{
+			templateKeyMsg:   msg,
+			templateKeyAttrs: AttrValues(evt),
+		})
+		switch err {
+		case nil:
+			txt.TextData = buf.String()
+		default:
+			err = fmt.Errorf("%w: template: %s", ErrConversion, err)
+		}
+	}
+	return
+}
+
+// AttrValues returns the event attribute values as the native ones: string, int32, bool, []byte or time.Time.
+func AttrValues(evt *pb.CloudEvent) (vals map[string]any) {
+	vals = make(map[string]any, len(evt.Attributes))
+	for k, a := range evt.Attributes {
+		switch at := a.GetAttr().(type) {
+		case *pb.CloudEventAttributeValue_CeBoolean:
+			vals[k] = at.CeBoolean
+		case *pb.CloudEventAttributeValue_CeInteger:
+			vals[k] = at.CeInteger
+		case *pb.CloudEventAttributeValue_CeString:
+			vals[k] = at.CeString
+		case *pb.CloudEventAttributeValue_CeBytes:
+			vals[k] = at.CeBytes
+		case *pb.CloudEventAttributeValue_CeUri:
+			vals[k] = at.CeUri
+		case *pb.CloudEventAttributeValue_CeUriRef:
+			vals[k] = at.CeUriRef
+		case *pb.CloudEventAttributeValue_CeTimestamp:
+			vals[k] = at.CeTimestamp.AsTime()
+		}
+	}
+	return
+}
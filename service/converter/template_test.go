@@ -0,0 +1,127 @@
+package converter
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestSvc_Convert_Template(t *testing.T) {
+	s := NewService("com_awakari_websocket_v1")
+	cases := map[string]struct {
+		url  string
+		tmpl string
+		raw  map[string]any
+		text string
+		err  error
+	}{
+		"seismicportal default": {
+			url: "wss://www.seismicportal.eu/standing_order/websocket",
+			raw: map[string]any{
+				"action": "create",
+				"data": map[string]any{
+					"properties": map[string]any{
+						"flynn_region": "CENTRAL ITALY",
+						"mag":          4.2,
+					},
+				},
+			},
+			text: "Earthquake\nMagnitude: 4.200000\nLocation: CENTRAL ITALY\n",
+		},
+		"seismicportal default zero magnitude": {
+			url: "wss://www.seismicportal.eu/standing_order/websocket",
+			raw: map[string]any{
+				"action": "create",
+				"data": map[string]any{
+					"properties": map[string]any{
+						"flynn_region": "CENTRAL ITALY",
+						"mag":          int64(0),
+					},
+				},
+			},
+			text: "Earthquake\nMagnitude: 0\nLocation: CENTRAL ITALY\n",
+		},
+		"coinbase default": {
+			url: "wss://ws-feed.exchange.coinbase.com",
+			raw: map[string]any{
+				"type":       "ticker",
+				"side":       "buy",
+				"price":      "1.5",
+				"product_id": "BTC-USD",
+			},
+			text: "Ticker\nProduct id: BTC-USD\nSide: buy\nPrice: 1.5\n",
+		},
+		"coinbase default w/o product id and side": {
+			url: "wss://ws-feed.exchange.coinbase.com",
+			raw: map[string]any{
+				"type":  "ticker",
+				"price": "1.5",
+			},
+			text: "Price: 1.5\n",
+		},
+		"blockchain default": {
+			url: "wss://ws.blockchain.info/inv",
+			raw: map[string]any{
+				"op": "block",
+				"x": map[string]any{
+					"hash":   "abc",
+					"reward": int64(0),
+				},
+			},
+			text: "New blockchain block created\nReward: 0\nHash: abc\n",
+		},
+		"generic has no default": {
+			url: "wss://example.com",
+			raw: map[string]any{
+				"text": "hello",
+			},
+			text: "hello",
+		},
+		"stream template": {
+			url:  "wss://ws-feed.exchange.coinbase.com",
+			tmpl: `{{.attrs.productid}} {{upper .attrs.side}} @ {{.msg.price}}`,
+			raw: map[string]any{
+				"type":       "ticker",
+				"side":       "sell",
+				"price":      "1.5",
+				"product_id": "BTC-USD",
+			},
+			text: "BTC-USD SELL @ 1.5",
+		},
+		"stream template failure": {
+			url:  "wss://example.com",
+			tmpl: `{{upper .msg.count}}`,
+			raw: map[string]any{
+				"text":  "hello",
+				"count": int64(1),
+			},
+			err: ErrConversion,
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			tmpl, err := CompileTemplate(c.tmpl)
+			require.Nil(t, err)
+			src := Source{
+				Url:      c.url,
+				Template: tmpl,
+			}
+			evts, _, err := s.Convert(src, c.raw)
+			assert.ErrorIs(t, err, c.err)
+			if c.err == nil {
+				require.Len(t, evts, 1)
+				assert.Equal(t, c.text, evts[0].GetTextData())
+			}
+		})
+	}
+}
+
+func TestCompileTemplate(t *testing.T) {
+	tmpl, err := CompileTemplate("")
+	assert.Nil(t, err)
+	assert.Nil(t, tmpl)
+	_, err = CompileTemplate("{{.msg")
+	assert.ErrorIs(t, err, ErrTemplate)
+	_, err = CompileTemplate("{{foo .msg}}")
+	assert.ErrorIs(t, err, ErrTemplate)
+}
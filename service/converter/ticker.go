@@ -1,10 +1,36 @@
 package converter
 
 import (
-	"fmt"
 	"github.com/cloudevents/sdk-go/binding/format/protobuf/v2/pb"
 )
 
+// for details see: https://docs.cdp.coinbase.com/exchange/docs/websocket-channels#ticker-channel
+
+var textCoinbaseTicker = mustTemplate("coinbase", `{{if or (has .attrs "productid") (has .attrs "side")}}Ticker
+{{end}}{{if has .attrs "productid"}}Product id: {{.attrs.productid}}
+{{end}}{{if has .attrs "side"}}Side: {{.attrs.side}}
+{{end}}{{if has .msg "price"}}Price: {{.msg.price}}
+{{end}}`)
+
+var convSchemaCoinbaseTicker = map[string]any{
+	"best_ask":      toAttrStringFunc("bestbask"),
+	"best_ask_size": toAttrStringFunc("bestasksize"),
+	"best_bid":      toAttrStringFunc("bestbid"),
+	"best_bid_size": toAttrStringFunc("bestbidsize"),
+	"high_24h":      toAttrInt32ElseStringFunc("high24h"),
+	"last_size":     toAttrInt32ElseStringFunc("lastsize"),
+	"low_24h":       toAttrInt32ElseStringFunc("low24h"),
+	"open_24h":      toAttrInt32ElseStringFunc("open24h"),
+	"price":         toAttrInt32ElseStringFunc("offersprice"),
+	"product_id":    convertTickerProductIdFunc("productid"),
+	"sequence":      toAttrInt32ElseStringFunc("sequence"),
+	"side":          convertTickerSideFunc("side"),
+	"time":          toAttrTimestampFunc("time"),
+	"trade_id":      toAttrInt32ElseStringFunc("tradeid"),
+	"volume_24h":    toAttrInt32ElseStringFunc("volume24h"),
+	"volume_30d":    toAttrInt32ElseStringFunc("volume30d"),
+}
+
 func convertTickerProductIdFunc(k string) ConvertFunc {
 	return func(evt *pb.CloudEvent, v any) (err error) {
 		var pid string
@@ -15,14 +41,6 @@ func convertTickerProductIdFunc(k string) ConvertFunc {
 					CeString: pid,
 				},
 			}
-			txt := evt.Data.(*pb.CloudEvent_TextData).TextData
-			switch txt {
-			case "":
-				txt = fmt.Sprintf("Ticker\nProduct id: %s\n", pid)
-			default:
-				txt += fmt.Sprintf("Product id: %s\n", pid)
-			}
-			evt.Data.(*pb.CloudEvent_TextData).TextData = txt
 		}
 		return
 	}
@@ -38,14 +56,6 @@ func convertTickerSideFunc(k string) ConvertFunc {
 					CeString: side,
 				},
 			}
-			txt := evt.Data.(*pb.CloudEvent_TextData).TextData
-			switch txt {
-			case "":
-				txt = fmt.Sprintf("Ticker\nSide: %s\n", side)
-			default:
-				txt += fmt.Sprintf("Side: %s\n", side)
-			}
-			evt.Data.(*pb.CloudEvent_TextData).TextData = txt
 		}
 		return
 	}
@@ -0,0 +1,242 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/awakari/source-websocket/model"
+	"github.com/awakari/source-websocket/service/converter"
+	"github.com/coder/websocket"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"io"
+	"math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// decoder turns the websocket frame into the messages to convert, a frame may contain several messages.
+type decoder func(typ websocket.MessageType, data []byte) (msgs []map[string]any, err error)
+
+var ErrDecode = errors.New("decoding failure")
+var ErrTooLarge = errors.New("message is too large")
+
+var decoders = map[model.Format]decoder{
+	model.FormatJson:    decodeJson,
+	model.FormatText:    decodeText,
+	model.FormatLines:   decodeLines,
+	model.FormatBinary:  decodeBinary,
+	model.FormatMsgpack: decodeMsgpack,
+	model.FormatCbor:    decodeCbor,
+}
+
+func newDecoder(str model.Stream) (dec decoder, err error) {
+	switch str.Format {
+	case model.FormatProtobuf:
+		dec, err = newProtobufDecoder(str.Protobuf)
+	default:
+		var ok bool
+		dec, ok = decoders[str.Format]
+		if !ok {
+			err = fmt.Errorf("unknown format %d", str.Format)
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("%w: %s", ErrInvalid, err)
+	}
+	return
+}
+
+func decodeJson(_ websocket.MessageType, data []byte) (msgs []map[string]any, err error) {
+	var v any
+	err = json.Unmarshal(data, &v)
+	if err == nil {
+		msgs, err = toMessages(v)
+	}
+	if err != nil {
+		err = fmt.Errorf("%w: json: %s", ErrDecode, err)
+	}
+	return
+}
+
+func decodeText(_ websocket.MessageType, data []byte) (msgs []map[string]any, err error) {
+	msgs = append(msgs, map[string]any{
+		converter.KeyText: string(data),
+	})
+	return
+}
+
+func decodeLines(_ websocket.MessageType, data []byte) (msgs []map[string]any, err error) {
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if len(bytes.TrimSpace(line)) > 0 {
+			msgs = append(msgs, map[string]any{
+				converter.KeyText: string(line),
+			})
+		}
+	}
+	return
+}
+
+func decodeBinary(_ websocket.MessageType, data []byte) (msgs []map[string]any, err error) {
+	msgs = append(msgs, map[string]any{
+		converter.KeyBinary: data,
+	})
+	return
+}
+
+func decodeMsgpack(_ websocket.MessageType, data []byte) (msgs []map[string]any, err error) {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	// the map keys are not necessarily strings
+	dec.SetMapDecoder(func(d *msgpack.Decoder) (any, error) {
+		return d.DecodeUntypedMap()
+	})
+	var v any
+	v, err = dec.DecodeInterface()
+	if err == nil {
+		msgs, err = toMessages(normalize(v))
+	}
+	if err != nil {
+		err = fmt.Errorf("%w: msgpack: %s", ErrDecode, err)
+	}
+	return
+}
+
+func decodeCbor(_ websocket.MessageType, data []byte) (msgs []map[string]any, err error) {
+	var v any
+	err = cbor.Unmarshal(data, &v)
+	if err == nil {
+		msgs, err = toMessages(normalize(v))
+	}
+	if err != nil {
+		err = fmt.Errorf("%w: cbor: %s", ErrDecode, err)
+	}
+	return
+}
+
+// toMessages accepts the object or the array, the array is wrapped into the message to be split by the converter.
+func toMessages(v any) (msgs []map[string]any, err error) {
+	switch vt := v.(type) {
+	case map[string]any:
+		msgs = append(msgs, vt)
+	case []any:
+		msgs = append(msgs, map[string]any{
+			converter.KeyItems: vt,
+		})
+	default:
+		err = fmt.Errorf("expected an object or an array, got %T", v)
+	}
+	return
+}
+
+// normalize makes the decoded binary value look like the decoded JSON for the converter: maps have string keys,
+// integers are int64 (or the string when out of the range), floats are float64 and times are RFC3339 strings.
+func normalize(v any) (n any) {
+	switch vt := v.(type) {
+	case nil, bool, string, []byte, int64, float64:
+		n = vt
+	case int:
+		n = int64(vt)
+	case int8:
+		n = int64(vt)
+	case int16:
+		n = int64(vt)
+	case int32:
+		n = int64(vt)
+	case uint:
+		n = normalizeUint(uint64(vt))
+	case uint8:
+		n = int64(vt)
+	case uint16:
+		n = int64(vt)
+	case uint32:
+		n = int64(vt)
+	case uint64:
+		n = normalizeUint(vt)
+	case float32:
+		n = float64(vt)
+	case time.Time:
+		n = vt.UTC().Format(time.RFC3339Nano)
+	case cbor.Tag:
+		n = normalize(vt.Content)
+	case big.Int:
+		n = vt.String()
+	case map[string]any:
+		m := make(map[string]any, len(vt))
+		for k, e := range vt {
+			m[k] = normalize(e)
+		}
+		n = m
+	case []any:
+		s := make([]any, len(vt))
+		for i, e := range vt {
+			s[i] = normalize(e)
+		}
+		n = s
+	default:
+		n = normalizeReflect(reflect.ValueOf(v))
+	}
+	return
+}
+
+func normalizeUint(u uint64) (n any) {
+	switch {
+	case u > math.MaxInt64:
+		n = strconv.FormatUint(u, 10)
+	default:
+		n = int64(u)
+	}
+	return
+}
+
+// normalizeReflect handles the maps w/ non-string keys and the typed slices.
+func normalizeReflect(v reflect.Value) (n any) {
+	switch v.Kind() {
+	case reflect.Map:
+		m := make(map[string]any, v.Len())
+		for iter := v.MapRange(); iter.Next(); {
+			m[fmt.Sprint(normalize(iter.Key().Interface()))] = normalize(iter.Value().Interface())
+		}
+		n = m
+	case reflect.Slice, reflect.Array:
+		s := make([]any, v.Len())
+		for i := range v.Len() {
+			s[i] = normalize(v.Index(i).Interface())
+		}
+		n = s
+	default:
+		n = fmt.Sprint(v.Interface())
+	}
+	return
+}
+
+// read reads the next frame and decodes it. The decoding error is wrapped with ErrDecode, the frame exceeding the read
+// limit is skipped w/o closing the connection and ErrTooLarge is returned. Any other error means the connection is not
+// usable anymore.
+func (h *handler) read(ctx context.Context, conn *websocket.Conn) (msgs []map[string]any, err error) {
+	var typ websocket.MessageType
+	var r io.Reader
+	typ, r, err = conn.Reader(ctx)
+	var data []byte
+	if err == nil {
+		// read one byte more to detect the excess
+		data, err = io.ReadAll(io.LimitReader(r, h.readLimit+1))
+	}
+	if err == nil && int64(len(data)) > h.readLimit {
+		var skipped int64
+		skipped, err = io.Copy(io.Discard, r)
+		if err == nil {
+			h.countOversized()
+			err = fmt.Errorf("%w: %d bytes exceeds the limit of %d", ErrTooLarge, int64(len(data))+skipped, h.readLimit)
+		}
+		data = nil
+	}
+	if err == nil {
+		msgs, err = h.decode(typ, data)
+	}
+	return
+}
@@ -0,0 +1,236 @@
+package handler
+
+import (
+	"encoding/hex"
+	"github.com/awakari/source-websocket/model"
+	"github.com/awakari/source-websocket/service/converter"
+	"github.com/coder/websocket"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+	"math"
+	"testing"
+)
+
+func TestDecoders(t *testing.T) {
+	cases := map[string]struct {
+		format model.Format
+		typ    websocket.MessageType
+		in     []byte
+		out    []map[string]any
+		err    error
+	}{
+		"json": {
+			typ: websocket.MessageText,
+			in:  []byte(`{"price":"123.45","sequence":42}`),
+			out: []map[string]any{
+				{
+					"price":    "123.45",
+					"sequence": float64(42),
+				},
+			},
+		},
+		"json array": {
+			typ: websocket.MessageText,
+			in:  []byte(`["EVENT","sub1",{"kind":1}]`),
+			out: []map[string]any{
+				{
+					"items": []any{
+						"EVENT",
+						"sub1",
+						map[string]any{
+							"kind": float64(1),
+						},
+					},
+				},
+			},
+		},
+		"json string": {
+			typ: websocket.MessageText,
+			in:  []byte(`"hello"`),
+			err: ErrDecode,
+		},
+		"json null": {
+			typ: websocket.MessageText,
+			in:  []byte(`null`),
+			err: ErrDecode,
+		},
+		"json binary": {
+			typ: websocket.MessageBinary,
+			in:  []byte{0x1f, 0x8b},
+			err: ErrDecode,
+		},
+		"text": {
+			format: model.FormatText,
+			typ:    websocket.MessageText,
+			in:     []byte("M 4.2 - NEAR COAST OF CHILE\n"),
+			out: []map[string]any{
+				{
+					"text": "M 4.2 - NEAR COAST OF CHILE\n",
+				},
+			},
+		},
+		"lines": {
+			format: model.FormatLines,
+			typ:    websocket.MessageText,
+			in:     []byte("first\r\nsecond\n\n  \nthird"),
+			out: []map[string]any{
+				{
+					"text": "first",
+				},
+				{
+					"text": "second",
+				},
+				{
+					"text": "third",
+				},
+			},
+		},
+		"lines empty": {
+			format: model.FormatLines,
+			typ:    websocket.MessageText,
+			in:     []byte("\n"),
+		},
+		"binary": {
+			format: model.FormatBinary,
+			typ:    websocket.MessageBinary,
+			in:     []byte{0x1f, 0x8b},
+			out: []map[string]any{
+				{
+					"binary": []byte{0x1f, 0x8b},
+				},
+			},
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			out, err := decoders[c.format](c.typ, c.in)
+			assert.Equal(t, c.out, out)
+			assert.ErrorIs(t, err, c.err)
+		})
+	}
+}
+
+func TestDecoders_Binary(t *testing.T) {
+	src := converter.Source{
+		Url: "wss://ws-feed.exchange.coinbase.com",
+	}
+	conv := converter.NewService("com_awakari_websocket_v1")
+	evtsJson, _, err := conv.Convert(src, map[string]any{
+		"price":    "1.5",
+		"sequence": float64(12345),
+		"low_24h":  0.5,
+		"time":     "2025-01-01T00:00:00Z",
+	})
+	require.Nil(t, err)
+	cases := map[string]struct {
+		format model.Format
+		frame  string
+		out    map[string]any
+	}{
+		"msgpack": {
+			format: model.FormatMsgpack,
+			frame:  "84a57072696365a3312e35a873657175656e6365cd3039a76c6f775f323468ca3f000000a474696d65ce67748580",
+			out: map[string]any{
+				"price":    "1.5",
+				"sequence": int64(12345),
+				"low_24h":  0.5,
+				"time":     int64(1735689600),
+			},
+		},
+		"cbor": {
+			format: model.FormatCbor,
+			frame:  "a465707269636563312e356873657175656e6365193039676c6f775f323468fa3f0000006474696d65c11a67748580",
+			out: map[string]any{
+				"price":    "1.5",
+				"sequence": int64(12345),
+				"low_24h":  0.5,
+				"time":     "2025-01-01T00:00:00Z",
+			},
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			frame, err := hex.DecodeString(c.frame)
+			require.Nil(t, err)
+			msgs, err := decoders[c.format](websocket.MessageBinary, frame)
+			require.Nil(t, err)
+			require.Equal(t, []map[string]any{c.out}, msgs)
+			evts, _, err := conv.Convert(src, msgs[0])
+			require.Nil(t, err)
+			require.Len(t, evts, 1)
+			assert.Equal(t, evtsJson[0].Attributes, evts[0].Attributes)
+			assert.Equal(t, evtsJson[0].Data, evts[0].Data)
+		})
+	}
+}
+
+func TestDecoders_BinaryRoundTrip(t *testing.T) {
+	in := map[string]any{
+		"product_id": "BTC-USD",
+		"trade_id":   uint32(42),
+		"last_size":  float32(0.25),
+		"sequence":   uint64(math.MaxUint64),
+		"high_24h":   int8(-1),
+		"x": map[int]any{
+			1: []uint16{2, 3},
+		},
+	}
+	out := map[string]any{
+		"product_id": "BTC-USD",
+		"trade_id":   int64(42),
+		"last_size":  0.25,
+		"sequence":   "18446744073709551615",
+		"high_24h":   int64(-1),
+		"x": map[string]any{
+			"1": []any{
+				int64(2),
+				int64(3),
+			},
+		},
+	}
+	marshallers := map[model.Format]func(v any) ([]byte, error){
+		model.FormatMsgpack: msgpack.Marshal,
+		model.FormatCbor:    cbor.Marshal,
+	}
+	for f, marshal := range marshallers {
+		t.Run(f.String(), func(t *testing.T) {
+			frame, err := marshal(in)
+			require.Nil(t, err)
+			msgs, err := decoders[f](websocket.MessageBinary, frame)
+			require.Nil(t, err)
+			assert.Equal(t, []map[string]any{out}, msgs)
+		})
+	}
+}
+
+func TestDecoders_BinaryInvalid(t *testing.T) {
+	cases := map[string]struct {
+		format model.Format
+		frame  []byte
+	}{
+		"msgpack truncated": {
+			format: model.FormatMsgpack,
+			frame:  []byte{0x82, 0xa5, 'p'},
+		},
+		"msgpack scalar": {
+			format: model.FormatMsgpack,
+			frame:  []byte{0x01},
+		},
+		"cbor truncated": {
+			format: model.FormatCbor,
+			frame:  []byte{0xa2, 0x65, 'p'},
+		},
+		"cbor scalar": {
+			format: model.FormatCbor,
+			frame:  []byte{0x01},
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			_, err := decoders[c.format](websocket.MessageBinary, c.frame)
+			assert.ErrorIs(t, err, ErrDecode)
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/awakari/source-websocket/model"
+	"github.com/awakari/source-websocket/service/converter"
+	"github.com/cloudevents/sdk-go/binding/format/protobuf/v2/pb"
+	"github.com/google/cel-go/cel"
+	"time"
+)
+
+// filterCostLimit bounds the evaluation cost of the filter expression per event.
+const filterCostLimit = 100_000
+
+var ErrFilter = errors.New("filter failure")
+
+// compileFilter returns nil when the expression is empty, e.g. msg.data.properties.mag >= 5.0 or
+// has(attrs.magnitude) && double(attrs.magnitude) >= 5.0, the fractional numbers are the string attributes.
+func compileFilter(src string) (prg cel.Program, err error) {
+	if src != "" {
+		// the variables are the decoded message and the converted event attributes
+		var env *cel.Env
+		env, err = cel.NewEnv(
+			cel.Variable("msg", cel.MapType(cel.StringType, cel.DynType)),
+			cel.Variable("attrs", cel.MapType(cel.StringType, cel.DynType)),
+		)
+		var ast *cel.Ast
+		if err == nil {
+			var iss *cel.Issues
+			ast, iss = env.Compile(src)
+			err = iss.Err()
+		}
+		// the dyn output is checked on the evaluation, e.g. for msg.data.flag
+		if err == nil && ast.OutputType() != cel.BoolType && ast.OutputType() != cel.DynType {
+			err = fmt.Errorf("filter should return bool, got %s", ast.OutputType())
+		}
+		if err == nil {
+			prg, err = env.Program(ast, cel.CostLimit(filterCostLimit))
+		}
+		if err != nil {
+			err = fmt.Errorf("%w: filter %q: %s", ErrInvalid, src, err)
+		}
+	}
+	return
+}
+
+// accept returns true when there's no filter or the filter evaluates to true for the event and the message it's
+// converted from, see converter.Filter. The evaluation failure, e.g. because of the absent key or the value of the
+// unexpected type, rejects the event like false does: the messages of the same stream differ in shape, so this is
+// expected for some of them. The failure is still kept in the status, see setFilterError.
+func (h *handler) accept(msg map[string]any, evt *pb.CloudEvent) (ok bool, err error) {
+	ok = h.filter == nil
+	if !ok {
+		out, _, errEval := h.filter.Eval(map[string]any{
+			"msg":   msg,
+			"attrs": converter.AttrValues(evt),
+		})
+		switch errEval {
+		case nil:
+			var outOk bool
+			ok, outOk = out.Value().(bool)
+			if !outOk {
+				err = fmt.Errorf("%w: returned %s, expected bool", ErrFilter, out.Type())
+			}
+		default:
+			h.setFilterError(errEval)
+		}
+	}
+	return
+}
+
+// setFilterError sets the last error of the status to the filter evaluation failure at most once per the status
+// interval, so the broken filter rejecting every event, e.g. because of a typo in the key, can be diagnosed.
+func (h *handler) setFilterError(err error) {
+	t := time.Now()
+	if t.Sub(h.filterErrAt) >= h.cfgHandler.Status.Interval {
+		h.filterErrAt = t
+		h.setError(model.StateReceiving, fmt.Errorf("%w: %s, the event is rejected", ErrFilter, err))
+	}
+}
@@ -0,0 +1,239 @@
+package handler
+
+import (
+	"github.com/awakari/source-websocket/service/converter"
+	"github.com/awakari/source-websocket/storage"
+	"github.com/cloudevents/sdk-go/binding/format/protobuf/v2/pb"
+	"github.com/google/cel-go/cel"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestCompileFilter(t *testing.T) {
+	cases := map[string]struct {
+		src string
+		nil bool
+		err error
+	}{
+		"empty": {
+			nil: true,
+		},
+		"ok": {
+			src: `msg.type == "ticker" && attrs.productid == "BTC-USD"`,
+		},
+		"syntax": {
+			src: `msg.type ==`,
+			err: ErrInvalid,
+		},
+		"dyn": {
+			src: `msg.active`,
+		},
+		"not bool": {
+			src: `1 + 2`,
+			err: ErrInvalid,
+		},
+		"unknown variable": {
+			src: `evt.id == "1"`,
+			err: ErrInvalid,
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			prg, err := compileFilter(c.src)
+			assert.ErrorIs(t, err, c.err)
+			if c.err == nil {
+				assert.Equal(t, c.nil, prg == nil)
+			}
+		})
+	}
+}
+
+func TestHandler_Accept(t *testing.T) {
+	evt := &pb.CloudEvent{
+		Attributes: map[string]*pb.CloudEventAttributeValue{
+			"magnitude": {
+				Attr: &pb.CloudEventAttributeValue_CeString{
+					CeString: "5.2",
+				},
+			},
+			"sequence": {
+				Attr: &pb.CloudEventAttributeValue_CeInteger{
+					CeInteger: 42,
+				},
+			},
+			"time": {
+				Attr: &pb.CloudEventAttributeValue_CeTimestamp{
+					CeTimestamp: timestamppb.New(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)),
+				},
+			},
+		},
+	}
+	msg := map[string]any{
+		"active": true,
+		"action": "create",
+		"data": map[string]any{
+			"mag": 5.2,
+		},
+	}
+	cases := map[string]struct {
+		src string
+		ok  bool
+		err error
+	}{
+		"no filter": {
+			ok: true,
+		},
+		"message": {
+			src: `msg.data.mag >= 5.0`,
+			ok:  true,
+		},
+		"message rejected": {
+			src: `msg.data.mag >= 6.0`,
+		},
+		"string attribute": {
+			src: `double(attrs.magnitude) > 5.0`,
+			ok:  true,
+		},
+		"int attribute": {
+			src: `attrs.sequence == 42`,
+			ok:  true,
+		},
+		"timestamp attribute": {
+			src: `attrs.time < timestamp("2026-01-01T00:00:00Z")`,
+			ok:  true,
+		},
+		"missing key rejected": {
+			src: `msg.data.depth > 10.0`,
+		},
+		"mistyped attribute rejected": {
+			src: `attrs.magnitude > 5.0`,
+		},
+		"dyn bool": {
+			src: `msg.active`,
+			ok:  true,
+		},
+		"dyn not bool": {
+			src: `msg.action`,
+			err: ErrFilter,
+		},
+		"missing key checked": {
+			src: `has(msg.data.depth) && msg.data.depth > 10.0`,
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			prg, err := compileFilter(c.src)
+			require.Nil(t, err)
+			h := newFilterHandler(prg)
+			ok, err := h.accept(msg, evt)
+			assert.Equal(t, c.ok, ok)
+			assert.ErrorIs(t, err, c.err)
+		})
+	}
+}
+
+func TestHandler_Accept_Error(t *testing.T) {
+	evt := &pb.CloudEvent{
+		Attributes: map[string]*pb.CloudEventAttributeValue{
+			"magnitude": {
+				Attr: &pb.CloudEventAttributeValue_CeString{
+					CeString: "5.2",
+				},
+			},
+		},
+	}
+	prg, err := compileFilter(`double(attrs.magnitdue) > 5.0`)
+	require.Nil(t, err)
+	h := newFilterHandler(prg)
+	for i := 0; i < 3; i++ {
+		ok, err := h.accept(map[string]any{}, evt)
+		assert.False(t, ok)
+		assert.Nil(t, err)
+	}
+	st := h.Status()
+	assert.Contains(t, st.LastError, ErrFilter.Error())
+	assert.Contains(t, st.LastError, "magnitdue")
+	assert.Equal(t, uint64(1), st.CountErrors)
+}
+
+func TestHandler_Accept_Seismicportal(t *testing.T) {
+	conv := converter.NewService("com_awakari_websocket_v1")
+	cases := map[string]struct {
+		src      string
+		mag      any
+		ok       bool
+		rejected int
+	}{
+		"fractional": {
+			src: `has(attrs.magnitude) && double(attrs.magnitude) >= 5.0`,
+			mag: 5.5,
+			ok:  true,
+		},
+		"fractional below": {
+			src:      `has(attrs.magnitude) && double(attrs.magnitude) >= 5.0`,
+			mag:      4.2,
+			rejected: 1,
+		},
+		"integer": {
+			src: `has(attrs.magnitude) && double(attrs.magnitude) >= 5.0`,
+			mag: 6.0,
+			ok:  true,
+		},
+		"absent": {
+			src:      `has(attrs.magnitude) && double(attrs.magnitude) >= 5.0`,
+			rejected: 1,
+		},
+		"fractional compared as number": {
+			src:      `attrs.magnitude >= 5.0`,
+			mag:      5.5,
+			rejected: 1,
+		},
+		"absent w/o has": {
+			src:      `double(attrs.magnitude) >= 5.0`,
+			rejected: 1,
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			prg, err := compileFilter(c.src)
+			require.Nil(t, err)
+			h := newFilterHandler(prg)
+			props := map[string]any{
+				"flynn_region": "CENTRAL ITALY",
+				"unid":         "20250101_0000001",
+			}
+			if c.mag != nil {
+				props["mag"] = c.mag
+			}
+			raw := map[string]any{
+				"action": "create",
+				"data": map[string]any{
+					"properties": props,
+				},
+			}
+			src := converter.Source{
+				Url:    "wss://www.seismicportal.eu/standing_order/websocket",
+				Filter: h.accept,
+			}
+			evts, skipped, err := conv.Convert(src, raw)
+			require.Nil(t, err)
+			assert.Equal(t, c.ok, len(evts) == 1)
+			assert.Equal(t, c.rejected, skipped.Rejected)
+		})
+	}
+}
+
+func newFilterHandler(prg cel.Program) *handler {
+	_, cfgHandler := newTestConfig()
+	return &handler{
+		url:        "wss://stream.example.com",
+		cfgHandler: cfgHandler,
+		stor:       storage.NewMockStorage(),
+		log:        slog.Default(),
+		filter:     prg,
+	}
+}
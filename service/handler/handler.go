@@ -9,98 +9,501 @@ import (
 	"github.com/awakari/source-websocket/config"
 	"github.com/awakari/source-websocket/model"
 	"github.com/awakari/source-websocket/service/converter"
+	"github.com/awakari/source-websocket/storage"
 	"github.com/cenkalti/backoff/v4"
 	"github.com/cloudevents/sdk-go/binding/format/protobuf/v2/pb"
 	"github.com/coder/websocket"
 	"github.com/coder/websocket/wsjson"
+	"github.com/google/cel-go/cel"
 	"io"
 	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"text/template"
 	"time"
 )
 
 type Handler interface {
 	io.Closer
 	Handle(ctx context.Context)
+	Status() model.Status
 }
 
 type handler struct {
-	url    string
-	str    model.Stream
-	cfgApi config.ApiConfig
-	conv   converter.Service
-	svcPub pub.Service
-	log    *slog.Logger
+	url            string
+	str            model.Stream
+	cfgApi         config.ApiConfig
+	cfgHandler     config.HandlerConfig
+	conv           converter.Service
+	svcPub         pub.Service
+	stor           storage.Storage
+	log            *slog.Logger
+	replies        []replyRule
+	handshakeSteps []handshakeStep
+	decode         decoder
+	readLimit      int64
+	mappings       *converter.Mappings
+	tmpl           *template.Template
+	filter         cel.Program
+	script         *converter.Script
+	// skippedLoggedAt and filterErrAt are accessed by the reading goroutine only
+	skippedLoggedAt time.Time
+	filterErrAt     time.Time
 
-	conn *websocket.Conn
+	// stopCtx is cancelled when the handler is closed, this stops both dialing and the reconnect loop.
+	stopCtx context.Context
+	stop    context.CancelFunc
+	// done is closed when the Handle loop exits.
+	done chan struct{}
+
+	lock          sync.Mutex
+	started       bool
+	conn          *websocket.Conn
+	status        model.Status
+	statusSavedAt time.Time
+	// processing is true while the messages read are handled, e.g. published, and processedAt is when it was done
+	// last time. The pongs are not read meanwhile, so the ping failure doesn't mean the connection is dead.
+	processing  bool
+	processedAt time.Time
 }
 
 type Factory func(url string, str model.Stream) Handler
 
-func NewFactory(cfgApi config.ApiConfig, conv converter.Service, svcPub pub.Service, log *slog.Logger) Factory {
+const skippedSampleLenMax = 1024
+
+var ErrIdleTimeout = errors.New("idle timeout")
+var ErrPing = errors.New("ping failure")
+var ErrHeartbeat = errors.New("heartbeat failure")
+var ErrReply = errors.New("reply failure")
+var ErrPanic = errors.New("panic")
+
+func NewFactory(
+	cfgApi config.ApiConfig,
+	cfgHandler config.HandlerConfig,
+	conv converter.Service,
+	svcPub pub.Service,
+	stor storage.Storage,
+	log *slog.Logger,
+) Factory {
 	return func(url string, str model.Stream) Handler {
+		stopCtx, stop := context.WithCancel(context.Background())
+		// keep the counters from the previous run, if any
+		st := str.Status
+		st.State = model.StateUnknown
+		replies, err := compileReplies(str.Replies)
+		if err != nil {
+			log.Warn(fmt.Sprintf("ignoring the reply rules for %s: %s", url, err))
+		}
+		handshakeSteps, err := compileHandshake(str.Handshake)
+		if err != nil {
+			log.Warn(fmt.Sprintf("ignoring the handshake for %s: %s", url, err))
+		}
+		decode, err := newDecoder(str)
+		if err != nil {
+			log.Warn(fmt.Sprintf("using the default format for %s: %s", url, err))
+			decode = decodeJson
+		}
+		mappings, err := converter.CompileMappings(str.Mappings)
+		if err != nil {
+			log.Warn(fmt.Sprintf("ignoring the mappings for %s: %s", url, err))
+		}
+		tmpl, err := converter.CompileTemplate(str.Template)
+		if err != nil {
+			log.Warn(fmt.Sprintf("using the default text template for %s: %s", url, err))
+		}
+		filter, err := compileFilter(str.Filter)
+		if err != nil {
+			log.Warn(fmt.Sprintf("ignoring the filter for %s: %s", url, err))
+		}
+		script, err := converter.CompileScript(str.Script, scriptLimits(cfgHandler))
+		if err != nil {
+			log.Warn(fmt.Sprintf("ignoring the script for %s: %s", url, err))
+		}
+		readLimit := str.ReadLimit
+		if readLimit == 0 {
+			readLimit = cfgHandler.Read.Limit
+		}
+		decode = inflating(decode, str.Compression.Payload, cfgHandler.Inflate.SizeMax)
 		return &handler{
-			url:    url,
-			str:    str,
-			cfgApi: cfgApi,
-			conv:   conv,
-			svcPub: svcPub,
-			log:    log,
+			url:            url,
+			str:            str,
+			cfgApi:         cfgApi,
+			cfgHandler:     cfgHandler,
+			conv:           conv,
+			svcPub:         svcPub,
+			stor:           stor,
+			log:            log,
+			stopCtx:        stopCtx,
+			stop:           stop,
+			done:           make(chan struct{}),
+			status:         st,
+			replies:        replies,
+			handshakeSteps: handshakeSteps,
+			decode:         decode,
+			readLimit:      readLimit,
+			mappings:       mappings,
+			tmpl:           tmpl,
+			filter:         filter,
+			script:         script,
 		}
 	}
 }
 
-func (h *handler) Close() error {
-	return h.conn.Close(websocket.StatusNormalClosure, "")
+func scriptLimits(cfgHandler config.HandlerConfig) converter.ScriptLimits {
+	return converter.ScriptLimits{
+		Steps:     cfgHandler.Script.StepsMax,
+		Memory:    cfgHandler.Script.MemoryMax,
+		Input:     cfgHandler.Script.InputMax,
+		Events:    cfgHandler.Script.EventsMax,
+		Attrs:     cfgHandler.Script.AttrsMax,
+		StringLen: cfgHandler.Script.StringLenMax,
+	}
+}
+
+// Close stops the handler: it prevents any further reconnects, closes the current connection (if any) normally and
+// waits until the event being published at the moment (if any) is done.
+func (h *handler) Close() (err error) {
+	h.stop()
+	h.lock.Lock()
+	started := h.started
+	conn := h.conn
+	h.lock.Unlock()
+	if conn != nil {
+		err = conn.Close(websocket.StatusNormalClosure, "")
+	}
+	if started {
+		<-h.done
+	}
+	h.setState(model.StateStopped)
+	return
 }
 
 func (h *handler) Handle(ctx context.Context) {
-	b := backoff.NewExponentialBackOff()
-	handleFunc := func() error {
-		return h.handleStream(ctx)
+	h.lock.Lock()
+	if h.started || h.stopCtx.Err() != nil {
+		h.lock.Unlock()
+		return
+	}
+	h.started = true
+	h.lock.Unlock()
+	defer close(h.done)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stopCancel := context.AfterFunc(h.stopCtx, cancel)
+	defer stopCancel()
+	b := backoff.WithContext(
+		backoff.NewExponentialBackOff(backoff.WithMaxElapsedTime(h.cfgHandler.Backoff.MaxElapsed)),
+		ctx,
+	)
+	handleFunc := func() (err error) {
+		err = h.handleStream(ctx, b)
+		// the rejected subscription is not going to succeed w/o changing the stream
+		if err != nil && (h.stopCtx.Err() != nil || errors.Is(err, ErrRejected)) {
+			err = backoff.Permanent(err)
+		}
+		return
 	}
 	notifyErrFunc := func(err error, t time.Duration) {
 		h.log.Warn(fmt.Sprintf("failed to handle the stream from %s, cause: %s, retrying in %s", h.url, err, t))
+		h.setError(model.StateBackingOff, err)
 	}
-	for {
-		if err := backoff.RetryNotify(handleFunc, b, notifyErrFunc); err != nil {
-			panic(fmt.Sprintf("failed to handle the stream from %s, cause: %s", h.url, err))
+	for ctx.Err() == nil {
+		if err := backoff.RetryNotify(handleFunc, b, notifyErrFunc); err != nil && ctx.Err() == nil {
+			// give up w/o affecting other streams, the stream may be resumed later
+			h.log.Error(fmt.Sprintf("failed to handle the stream from %s, giving up, cause: %s", h.url, err))
+			h.setError(model.StateFailed, err)
+			break
 		}
 	}
 }
 
-func (h *handler) handleStream(ctx context.Context) (err error) {
-	h.conn, _, err = websocket.Dial(ctx, h.url, nil)
+func (h *handler) handleStream(ctx context.Context, b backoff.BackOff) (err error) {
+	h.setState(model.StateDialing)
+	var conn *websocket.Conn
+	conn, _, err = websocket.Dial(ctx, h.url, h.dialOptions())
 	if err == nil {
-		defer h.conn.CloseNow()
+		defer conn.CloseNow()
+		h.lock.Lock()
+		h.conn = conn
+		h.lock.Unlock()
+		defer func() {
+			h.lock.Lock()
+			h.conn = nil
+			h.lock.Unlock()
+		}()
+		// the read limit is applied per message by the handler to skip the large ones w/o closing the connection
+		conn.SetReadLimit(-1)
+		// the handler might be closed while dialing, in this case the connection is not visible to Close
+		if h.stopCtx.Err() != nil {
+			err = conn.Close(websocket.StatusNormalClosure, "")
+			return
+		}
+		// the connection is closed by the handler's Close, so reading should not be interrupted by the context
+		// cancellation: this would drop the connection w/o the normal closure
+		ctxConn := context.WithoutCancel(ctx)
 		if h.str.Request != "" {
 			var reqParsed map[string]any
 			err = json.Unmarshal([]byte(h.str.Request), &reqParsed)
 			if err == nil {
-				err = wsjson.Write(ctx, h.conn, reqParsed)
+				err = wsjson.Write(ctxConn, conn, reqParsed)
 			}
-
 		}
 		if err == nil {
-			for {
-				err = h.handleStreamEvent(ctx, h.url)
-				if err != nil && !errors.Is(err, converter.ErrConversion) {
-					break
+			err = h.handshake(ctxConn, conn)
+		}
+		if err == nil {
+			h.setConnected(conn.Subprotocol())
+			// the connection is established, so the next failure should not count the time spent while connected
+			b.Reset()
+			pingInterval, idleTimeout := h.keepalive()
+			// cancelled with the cause when the connection is considered dead, this closes the connection
+			ctxRead, cancelRead := context.WithCancelCause(ctxConn)
+			wg := &sync.WaitGroup{}
+			defer wg.Wait()
+			defer cancelRead(nil)
+			if pingInterval > 0 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					defer h.recoverConn(cancelRead)
+					h.ping(ctxRead, cancelRead, conn, pingInterval)
+				}()
+			}
+			for _, hb := range h.str.Heartbeats {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					defer h.recoverConn(cancelRead)
+					h.heartbeat(ctxRead, cancelRead, conn, hb)
+				}()
+			}
+			for ctx.Err() == nil {
+				err = h.handleStreamEvent(ctxRead, conn, idleTimeout)
+				if err != nil {
+					// a single message failure should not break the connection
+					if !isMessageError(err) {
+						break
+					}
+					h.setError(model.StateReceiving, err)
 				}
 			}
+			if cause := context.Cause(ctxRead); err != nil && cause != nil && !errors.Is(err, cause) {
+				err = fmt.Errorf("%w: %s", cause, err)
+			}
 		}
 	}
 	return
 }
 
-func (h *handler) handleStreamEvent(ctx context.Context, url string) (err error) {
-	var raw map[string]any
-	err = wsjson.Read(ctx, h.conn, &raw)
-	var evt *pb.CloudEvent
-	if err == nil {
-		evt, err = h.conv.Convert(url, raw)
+// keepalive returns the effective ping interval and idle timeout for the stream, non-positive means disabled.
+func (h *handler) keepalive() (pingInterval, idleTimeout time.Duration) {
+	pingInterval = h.str.Keepalive.PingInterval
+	if pingInterval == 0 {
+		pingInterval = h.cfgHandler.Keepalive.PingInterval
+	}
+	idleTimeout = h.str.Keepalive.IdleTimeout
+	if idleTimeout == 0 {
+		idleTimeout = h.cfgHandler.Keepalive.IdleTimeout
+	}
+	return
+}
+
+// ping sends the websocket pings periodically until the context is done. When a pong is not received within the
+// interval, the connection is considered dead and the context is cancelled.
+func (h *handler) ping(ctx context.Context, cancel context.CancelCauseFunc, conn *websocket.Conn, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		t := time.Now()
+		ctxPing, cancelPing := context.WithTimeout(ctx, interval)
+		err := conn.Ping(ctxPing)
+		cancelPing()
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil && h.processedSince(t):
+			// no pong could be read, try again on the next tick
+		case err != nil:
+			cancel(fmt.Errorf("%w: %s", ErrPing, err))
+			return
+		default:
+			h.setPong(time.Since(t))
+		}
+	}
+}
+
+func (h *handler) setProcessing(processing bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.processing = processing
+	if !processing {
+		h.processedAt = time.Now()
+	}
+}
+
+// processedSince returns true when the messages were handled instead of reading the connection after the time.
+func (h *handler) processedSince(t time.Time) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.processing || h.processedAt.After(t)
+}
+
+// recoverConn drops the connection when its background goroutine panics, the supervisor recovers the Handle goroutine
+// only.
+func (h *handler) recoverConn(cancel context.CancelCauseFunc) {
+	if p := recover(); p != nil {
+		h.log.Error(fmt.Sprintf("recovered the connection to %s: %v\n%s", h.url, p, debug.Stack()))
+		cancel(fmt.Errorf("%w: %v", ErrPanic, p))
+	}
+}
+
+// heartbeat sends the heartbeat message periodically until the context is done or the sending fails.
+func (h *handler) heartbeat(ctx context.Context, cancel context.CancelCauseFunc, conn *websocket.Conn, hb model.Heartbeat) {
+	ticker := time.NewTicker(hb.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		err := conn.Write(ctx, websocket.MessageText, []byte(hb.Message))
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil:
+			cancel(fmt.Errorf("%w: %s", ErrHeartbeat, err))
+			return
+		}
+	}
+}
+
+func (h *handler) handleStreamEvent(ctx context.Context, conn *websocket.Conn, idleTimeout time.Duration) (err error) {
+	ctxMsg := ctx
+	if idleTimeout > 0 {
+		var cancel context.CancelFunc
+		ctxMsg, cancel = context.WithTimeoutCause(ctx, idleTimeout, ErrIdleTimeout)
+		defer cancel()
+	}
+	var msgs []map[string]any
+	msgs, err = h.read(ctxMsg, conn)
+	switch {
+	case err == nil, errors.Is(err, ErrDecode):
+		h.countMessage()
+	case errors.Is(context.Cause(ctxMsg), ErrIdleTimeout):
+		err = fmt.Errorf("%w: no message within %s", ErrIdleTimeout, idleTimeout)
+		h.countIdleTimeout()
+	}
+	// the frame may contain several messages, a failure of one should not prevent the others
+	var errMsgs error
+	h.setProcessing(true)
+	defer h.setProcessing(false)
+	for i := 0; i < len(msgs) && err == nil; i++ {
+		err = h.handleMessage(ctx, conn, msgs[i])
+		if isMessageError(err) {
+			errMsgs = errors.Join(errMsgs, err)
+			err = nil
+		}
 	}
 	if err == nil {
-		err = h.svcPub.Publish(ctx, evt, h.cfgApi.GroupId, url)
+		err = errMsgs
+	}
+	return
+}
+
+func (h *handler) handleMessage(ctx context.Context, conn *websocket.Conn, msg map[string]any) (err error) {
+	var replied bool
+	replied, err = h.reply(ctx, conn, msg)
+	var evts []*pb.CloudEvent
+	if err == nil && !replied {
+		src := converter.Source{
+			Url:            h.url,
+			Subprotocol:    conn.Subprotocol(),
+			Split:          h.str.Split,
+			PublishNonData: h.str.PublishNonData,
+			Converter:      h.str.Converter,
+			Mappings:       h.mappings,
+			Template:       h.tmpl,
+			Script:         h.script,
+			Filter:         h.accept,
+		}
+		// the events converted successfully are published even if the conversion of others failed
+		var skipped converter.Skipped
+		evts, skipped, err = h.conv.Convert(src, msg)
+		if skipped != (converter.Skipped{}) {
+			h.countSkipped(skipped)
+		}
+		if skipped.Control > 0 || skipped.Unknown > 0 {
+			h.logSkipped(skipped, msg)
+		}
+	}
+	for i := 0; i < len(evts) && (err == nil || isMessageError(err)); i++ {
+		// publishing should complete even if the connection is considered dead meanwhile, every request is limited by
+		// the writer timeout of the publisher
+		errPub := h.svcPub.Publish(context.WithoutCancel(ctx), evts[i], h.cfgApi.GroupId, h.url)
+		if errPub != nil {
+			err = errPub
+		} else {
+			h.countEvent()
+		}
+	}
+	return
+}
+
+// logSkipped logs the sample of the skipped message at most once per the configured interval.
+func (h *handler) logSkipped(skipped converter.Skipped, msg map[string]any) {
+	interval := h.cfgHandler.Skipped.LogInterval
+	if interval > 0 && time.Since(h.skippedLoggedAt) >= interval {
+		h.skippedLoggedAt = time.Now()
+		sample, _ := json.Marshal(msg)
+		if len(sample) > skippedSampleLenMax {
+			sample = append(sample[:skippedSampleLenMax], "..."...)
+		}
+		h.log.Info(fmt.Sprintf("skipped %d control and %d unknown messages from %s, sample: %s", skipped.Control, skipped.Unknown, h.url, sample))
+	}
+}
+
+// isMessageError returns true when the error is specific to the message and the connection is still usable.
+func isMessageError(err error) bool {
+	return errors.Is(err, ErrDecode) ||
+		errors.Is(err, ErrTooLarge) ||
+		errors.Is(err, ErrFilter) ||
+		errors.Is(err, converter.ErrConversion) ||
+		errors.Is(err, ErrReply)
+}
+
+// reply answers the message when it matches any of the stream's reply rules, the answered message is not published.
+func (h *handler) reply(ctx context.Context, conn *websocket.Conn, msg map[string]any) (replied bool, err error) {
+	var data []byte
+	data, replied, err = reply(h.replies, msg)
+	switch {
+	case err != nil:
+		err = fmt.Errorf("%w: %s", ErrReply, err)
+	case replied:
+		err = conn.Write(ctx, websocket.MessageText, data)
+	}
+	return
+}
+
+func (h *handler) dialOptions() (opts *websocket.DialOptions) {
+	hdr := http.Header{}
+	for _, sh := range h.str.Headers {
+		hdr.Add(sh.Name, sh.Value)
+	}
+	// the stream headers may override the default user agent
+	if hdr.Get("User-Agent") == "" {
+		hdr.Set("User-Agent", h.cfgApi.UserAgent)
+	}
+	opts = &websocket.DialOptions{
+		HTTPHeader:      hdr,
+		Subprotocols:    h.str.Subprotocols,
+		CompressionMode: websocket.CompressionMode(h.str.Compression.Deflate),
 	}
 	return
 }
@@ -0,0 +1,627 @@
+package handler
+
+import (
+	"context"
+	"github.com/awakari/source-websocket/config"
+	"github.com/awakari/source-websocket/model"
+	"github.com/awakari/source-websocket/service/converter"
+	"github.com/awakari/source-websocket/storage"
+	"github.com/cloudevents/sdk-go/binding/format/protobuf/v2/pb"
+	"github.com/coder/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type pubRecorder struct {
+	lock  sync.Mutex
+	evts  []*pb.CloudEvent
+	delay time.Duration
+}
+
+func (p *pubRecorder) Publish(ctx context.Context, evt *pb.CloudEvent, groupId, userId string) (err error) {
+	select {
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-time.After(p.delay):
+		p.lock.Lock()
+		p.evts = append(p.evts, evt)
+		p.lock.Unlock()
+	}
+	return
+}
+
+func (p *pubRecorder) texts() (txts []string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, evt := range p.evts {
+		txts = append(txts, evt.GetTextData())
+	}
+	return
+}
+
+// newTestServer starts the websocket server calling serve for every connection, the connection is closed when serve
+// returns. The serve context is cancelled when the test ends.
+func newTestServer(t *testing.T, serve func(ctx context.Context, conn *websocket.Conn, r *http.Request)) (url string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
+			Subprotocols: []string{
+				"v2.test",
+			},
+		})
+		if err == nil {
+			defer conn.CloseNow()
+			serve(ctx, conn, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(cancel)
+	url = "ws" + strings.TrimPrefix(srv.URL, "http")
+	return
+}
+
+func newTestConfig() (cfgApi config.ApiConfig, cfgHandler config.HandlerConfig) {
+	cfgApi.UserAgent = "Awakari"
+	cfgApi.GroupId = "default"
+	cfgHandler.Backoff.MaxElapsed = time.Minute
+	cfgHandler.Handshake.Timeout = time.Second
+	cfgHandler.Inflate.SizeMax = 1 << 20
+	cfgHandler.Read.Limit = 1 << 10
+	cfgHandler.Script.StepsMax = 100_000
+	cfgHandler.Script.InputMax = 10_000
+	cfgHandler.Script.EventsMax = 100
+	cfgHandler.Script.AttrsMax = 100
+	cfgHandler.Script.StringLenMax = 1 << 10
+	cfgHandler.Status.Interval = time.Minute
+	cfgHandler.Status.Timeout = time.Second
+	return
+}
+
+func newTestHandler(cfgApi config.ApiConfig, cfgHandler config.HandlerConfig, svcPub *pubRecorder, url string, str model.Stream) Handler {
+	conv := converter.NewService("com_awakari_websocket_v1")
+	return NewFactory(cfgApi, cfgHandler, conv, svcPub, storage.NewMockStorage(), slog.Default())(url, str)
+}
+
+// handleAsync runs the handler until the test ends, done is closed when Handle returns.
+func handleAsync(t *testing.T, h Handler) (done chan struct{}) {
+	done = make(chan struct{})
+	go func() {
+		defer close(done)
+		h.Handle(context.Background())
+	}()
+	t.Cleanup(func() {
+		_ = h.Close()
+		<-done
+	})
+	return
+}
+
+func TestHandler_Handle_SplitFilter(t *testing.T) {
+	url := newTestServer(t, func(ctx context.Context, conn *websocket.Conn, r *http.Request) {
+		frame := `{"data":[{"text":"small","size":1},{"text":"big","size":5},{"text":"huge","size":9}]}`
+		_ = conn.Write(ctx, websocket.MessageText, []byte(frame))
+		_, _, _ = conn.Read(ctx)
+	})
+	cfgApi, cfgHandler := newTestConfig()
+	svcPub := &pubRecorder{}
+	h := newTestHandler(cfgApi, cfgHandler, svcPub, url, model.Stream{
+		Split:  "data",
+		Filter: `msg.size > 2`,
+	})
+	handleAsync(t, h)
+	require.Eventually(t, func() bool {
+		return len(svcPub.texts()) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"big", "huge"}, svcPub.texts())
+	require.Eventually(t, func() bool {
+		return h.Status().CountRejected == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestHandler_Close_BeforeHandle(t *testing.T) {
+	var conns atomic.Int32
+	url := newTestServer(t, func(ctx context.Context, conn *websocket.Conn, r *http.Request) {
+		conns.Add(1)
+	})
+	cfgApi, cfgHandler := newTestConfig()
+	h := newTestHandler(cfgApi, cfgHandler, &pubRecorder{}, url, model.Stream{})
+	require.Nil(t, h.Close())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.Handle(context.Background())
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Handle should return immediately after Close")
+	}
+	assert.Equal(t, model.StateStopped, h.Status().State)
+	assert.Equal(t, int32(0), conns.Load())
+}
+
+func TestHandler_Close_Connected(t *testing.T) {
+	var conns atomic.Int32
+	closeStatus := make(chan websocket.StatusCode, 1)
+	url := newTestServer(t, func(ctx context.Context, conn *websocket.Conn, r *http.Request) {
+		conns.Add(1)
+		_, _, err := conn.Read(ctx)
+		closeStatus <- websocket.CloseStatus(err)
+	})
+	cfgApi, cfgHandler := newTestConfig()
+	h := newTestHandler(cfgApi, cfgHandler, &pubRecorder{}, url, model.Stream{})
+	done := handleAsync(t, h)
+	require.Eventually(t, func() bool {
+		return h.Status().State == model.StateSubscribed
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Nil(t, h.Close())
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Handle should return after Close")
+	}
+	select {
+	case st := <-closeStatus:
+		assert.Equal(t, websocket.StatusNormalClosure, st)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the peer should see the connection closed")
+	}
+	assert.Equal(t, model.StateStopped, h.Status().State)
+	// no reconnect after Close, neither by the running nor by the new Handle call
+	h.Handle(context.Background())
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(1), conns.Load())
+}
+
+func TestHandler_Handle_BackoffExhausted(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}))
+	t.Cleanup(srv.Close)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	cfgApi, cfgHandler := newTestConfig()
+	cfgHandler.Backoff.MaxElapsed = 100 * time.Millisecond
+	h := newTestHandler(cfgApi, cfgHandler, &pubRecorder{}, url, model.Stream{})
+	done := handleAsync(t, h)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Handle should give up when the backoff is exhausted")
+	}
+	st := h.Status()
+	assert.Equal(t, model.StateFailed, st.State)
+	assert.Contains(t, st.LastError, "403")
+	assert.False(t, st.LastErrorAt.IsZero())
+}
+
+func TestHandler_Handle_Rejected(t *testing.T) {
+	var conns atomic.Int32
+	url := newTestServer(t, func(ctx context.Context, conn *websocket.Conn, r *http.Request) {
+		conns.Add(1)
+		_, _, _ = conn.Read(ctx)
+		_ = conn.Write(ctx, websocket.MessageText, []byte(`{"type":"error","message":"unknown channel"}`))
+		_, _, _ = conn.Read(ctx)
+	})
+	cfgApi, cfgHandler := newTestConfig()
+	h := newTestHandler(cfgApi, cfgHandler, &pubRecorder{}, url, model.Stream{
+		Handshake: []model.HandshakeStep{
+			{
+				Message: `{"type":"subscribe"}`,
+				Ack:     `{"type":"subscriptions"}`,
+				Error:   `{"type":"error"}`,
+			},
+		},
+	})
+	done := handleAsync(t, h)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Handle should give up w/o retrying when the subscription is rejected")
+	}
+	st := h.Status()
+	assert.Equal(t, model.StateFailed, st.State)
+	assert.Contains(t, st.LastError, ErrRejected.Error())
+	assert.Contains(t, st.LastError, "unknown channel")
+	assert.Equal(t, int32(1), conns.Load())
+}
+
+func TestHandler_Handle_HeartbeatPanic(t *testing.T) {
+	var conns atomic.Int32
+	url := newTestServer(t, func(ctx context.Context, conn *websocket.Conn, r *http.Request) {
+		conns.Add(1)
+		_, _, _ = conn.Read(ctx)
+	})
+	cfgApi, cfgHandler := newTestConfig()
+	h := newTestHandler(cfgApi, cfgHandler, &pubRecorder{}, url, model.Stream{
+		Heartbeats: []model.Heartbeat{
+			{
+				// the non-positive ticker interval panics
+				Message: `{"type":"ping"}`,
+			},
+		},
+	})
+	handleAsync(t, h)
+	require.Eventually(t, func() bool {
+		return strings.Contains(h.Status().LastError, ErrPanic.Error())
+	}, 5*time.Second, 10*time.Millisecond)
+	// the connection is dropped and established again, the process is alive
+	require.Eventually(t, func() bool {
+		return conns.Load() > 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestHandler_Handle_PingFailure(t *testing.T) {
+	var conns atomic.Int32
+	url := newTestServer(t, func(ctx context.Context, conn *websocket.Conn, r *http.Request) {
+		// the pings are answered only while reading
+		conns.Add(1)
+		<-ctx.Done()
+	})
+	cfgApi, cfgHandler := newTestConfig()
+	h := newTestHandler(cfgApi, cfgHandler, &pubRecorder{}, url, model.Stream{
+		Keepalive: model.Keepalive{
+			PingInterval: 50 * time.Millisecond,
+		},
+	})
+	handleAsync(t, h)
+	require.Eventually(t, func() bool {
+		return strings.Contains(h.Status().LastError, ErrPing.Error())
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		return conns.Load() > 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestHandler_Handle_IdleTimeout(t *testing.T) {
+	var conns atomic.Int32
+	url := newTestServer(t, func(ctx context.Context, conn *websocket.Conn, r *http.Request) {
+		conns.Add(1)
+		_, _, _ = conn.Read(ctx)
+	})
+	cfgApi, cfgHandler := newTestConfig()
+	h := newTestHandler(cfgApi, cfgHandler, &pubRecorder{}, url, model.Stream{
+		Keepalive: model.Keepalive{
+			IdleTimeout: 50 * time.Millisecond,
+		},
+	})
+	handleAsync(t, h)
+	require.Eventually(t, func() bool {
+		return strings.Contains(h.Status().LastError, ErrIdleTimeout.Error())
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		return conns.Load() > 1 && h.Status().CountIdleTimeouts > 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestHandler_Handle_SlowPublish(t *testing.T) {
+	var conns atomic.Int32
+	url := newTestServer(t, func(ctx context.Context, conn *websocket.Conn, r *http.Request) {
+		conns.Add(1)
+		go func() {
+			_, _, _ = conn.Read(ctx)
+		}()
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for ctx.Err() == nil {
+			_ = conn.Write(ctx, websocket.MessageText, []byte(`{"text":"hello"}`))
+			select {
+			case <-ctx.Done():
+			case <-ticker.C:
+			}
+		}
+	})
+	cfgApi, cfgHandler := newTestConfig()
+	svcPub := &pubRecorder{
+		delay: 300 * time.Millisecond,
+	}
+	h := newTestHandler(cfgApi, cfgHandler, svcPub, url, model.Stream{
+		Keepalive: model.Keepalive{
+			PingInterval: 50 * time.Millisecond,
+			IdleTimeout:  200 * time.Millisecond,
+		},
+	})
+	handleAsync(t, h)
+	// neither the pings nor the idle timeout drop the connection while publishing takes longer than both
+	require.Eventually(t, func() bool {
+		return len(svcPub.texts()) >= 3
+	}, 5*time.Second, 10*time.Millisecond)
+	st := h.Status()
+	assert.Equal(t, int32(1), conns.Load())
+	assert.Empty(t, st.LastError)
+	assert.Zero(t, st.CountIdleTimeouts)
+}
+
+func TestHandler_Handle_Handshake(t *testing.T) {
+	cases := map[string]struct {
+		frames    []string
+		texts     []string
+		received  []string
+		state     model.State
+		err       error
+		oversized uint64
+	}{
+		"ack": {
+			frames: []string{
+				`{"type":"subscriptions"}`,
+				`{"text":"after"}`,
+			},
+			texts: []string{
+				"after",
+			},
+		},
+		"reply and heartbeat before ack": {
+			frames: []string{
+				`{"type":"ping","id":"p1"}`,
+				`{"type":"heartbeat"}`,
+				`{"type":"subscriptions"}`,
+				`{"text":"after"}`,
+			},
+			texts: []string{
+				"after",
+			},
+			received: []string{
+				`{"type":"pong","id":"p1"}`,
+			},
+		},
+		"oversized before ack": {
+			frames: []string{
+				`{"text":"` + strings.Repeat("x", 2<<10) + `"}`,
+				`{"type":"subscriptions"}`,
+				`{"text":"after"}`,
+			},
+			texts: []string{
+				"after",
+			},
+			oversized: 1,
+		},
+		"rejected": {
+			frames: []string{
+				`{"type":"error","message":"unknown channel"}`,
+			},
+			state: model.StateFailed,
+			err:   ErrRejected,
+		},
+		"timeout": {
+			frames: []string{
+				`{"type":"heartbeat"}`,
+			},
+			state: model.StateBackingOff,
+			err:   ErrHandshakeTimeout,
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			var lock sync.Mutex
+			var received []string
+			url := newTestServer(t, func(ctx context.Context, conn *websocket.Conn, r *http.Request) {
+				_, sub, err := conn.Read(ctx)
+				if err != nil || string(sub) != `{"type":"subscribe"}` {
+					return
+				}
+				for _, f := range c.frames {
+					_ = conn.Write(ctx, websocket.MessageText, []byte(f))
+				}
+				for {
+					_, data, err := conn.Read(ctx)
+					if err != nil {
+						break
+					}
+					lock.Lock()
+					received = append(received, string(data))
+					lock.Unlock()
+				}
+			})
+			cfgApi, cfgHandler := newTestConfig()
+			svcPub := &pubRecorder{}
+			h := newTestHandler(cfgApi, cfgHandler, svcPub, url, model.Stream{
+				Handshake: []model.HandshakeStep{
+					{
+						Message: `{"type":"subscribe"}`,
+						Ack:     `{"type":"subscriptions"}`,
+						Error:   `{"type":"error"}`,
+						Timeout: 100 * time.Millisecond,
+					},
+				},
+				Replies: []model.Reply{
+					{
+						Match:    `{"type":"ping"}`,
+						Template: `{"type":"pong","id":"{{.id}}"}`,
+					},
+				},
+			})
+			handleAsync(t, h)
+			switch c.err {
+			case nil:
+				require.Eventually(t, func() bool {
+					return len(svcPub.texts()) == len(c.texts)
+				}, 5*time.Second, 10*time.Millisecond)
+				assert.Equal(t, c.texts, svcPub.texts())
+				assert.Empty(t, h.Status().LastError)
+				assert.Equal(t, c.oversized, h.Status().CountOversized)
+				require.Eventually(t, func() bool {
+					lock.Lock()
+					defer lock.Unlock()
+					return len(received) == len(c.received)
+				}, 5*time.Second, 10*time.Millisecond)
+				lock.Lock()
+				assert.Equal(t, c.received, received)
+				lock.Unlock()
+			default:
+				require.Eventually(t, func() bool {
+					st := h.Status()
+					return st.State == c.state && strings.Contains(st.LastError, c.err.Error())
+				}, 5*time.Second, 10*time.Millisecond)
+				assert.Empty(t, svcPub.texts())
+			}
+		})
+	}
+}
+
+func TestHandler_Handle_Oversized(t *testing.T) {
+	var conns atomic.Int32
+	url := newTestServer(t, func(ctx context.Context, conn *websocket.Conn, r *http.Request) {
+		conns.Add(1)
+		_ = conn.Write(ctx, websocket.MessageText, []byte(`{"text":"`+strings.Repeat("x", 2<<10)+`"}`))
+		_ = conn.Write(ctx, websocket.MessageText, []byte(`{"text":"next"}`))
+		_, _, _ = conn.Read(ctx)
+	})
+	cfgApi, cfgHandler := newTestConfig()
+	svcPub := &pubRecorder{}
+	h := newTestHandler(cfgApi, cfgHandler, svcPub, url, model.Stream{})
+	handleAsync(t, h)
+	require.Eventually(t, func() bool {
+		return len(svcPub.texts()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"next"}, svcPub.texts())
+	st := h.Status()
+	assert.Equal(t, uint64(1), st.CountOversized)
+	assert.Contains(t, st.LastError, ErrTooLarge.Error())
+	assert.Equal(t, int32(1), conns.Load())
+}
+
+func TestHandler_Handle_DialOptions(t *testing.T) {
+	cases := map[string]struct {
+		headers   []model.Header
+		userAgent string
+	}{
+		"default user agent": {
+			headers: []model.Header{
+				{
+					Name:   "Authorization",
+					Value:  "Bearer token0",
+					Secret: true,
+				},
+				{
+					Name:  "X-Api-Key",
+					Value: "key0",
+				},
+			},
+			userAgent: "Awakari",
+		},
+		"custom user agent": {
+			headers: []model.Header{
+				{
+					Name:  "User-Agent",
+					Value: "Custom/1.0",
+				},
+			},
+			userAgent: "Custom/1.0",
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			reqHeaders := make(chan http.Header, 1)
+			url := newTestServer(t, func(ctx context.Context, conn *websocket.Conn, r *http.Request) {
+				reqHeaders <- r.Header.Clone()
+				_ = conn.Write(ctx, websocket.MessageText, []byte(`{"text":"hello"}`))
+				_, _, _ = conn.Read(ctx)
+			})
+			cfgApi, cfgHandler := newTestConfig()
+			svcPub := &pubRecorder{}
+			h := newTestHandler(cfgApi, cfgHandler, svcPub, url, model.Stream{
+				Headers: c.headers,
+				Subprotocols: []string{
+					"v1.test",
+					"v2.test",
+				},
+			})
+			handleAsync(t, h)
+			var hdr http.Header
+			select {
+			case hdr = <-reqHeaders:
+			case <-time.After(5 * time.Second):
+				t.Fatal("no connection")
+			}
+			for _, sh := range c.headers {
+				// the secret header values are decrypted by the storage
+				assert.Equal(t, sh.Value, hdr.Get(sh.Name))
+			}
+			assert.Equal(t, c.userAgent, hdr.Get("User-Agent"))
+			assert.Equal(t, "v1.test,v2.test", hdr.Get("Sec-WebSocket-Protocol"))
+			require.Eventually(t, func() bool {
+				return len(svcPub.texts()) == 1
+			}, 5*time.Second, 10*time.Millisecond)
+			assert.Equal(t, "v2.test", h.Status().Subprotocol)
+			svcPub.lock.Lock()
+			// the negotiated subprotocol selects the converter but is not stamped on the events
+			assert.NotContains(t, svcPub.evts[0].Attributes, "subprotocol")
+			svcPub.lock.Unlock()
+		})
+	}
+}
+
+// deletableStorage fails to update the status when the stream is deleted meanwhile, e.g. via another replica.
+type deletableStorage struct {
+	storage.Storage
+	deleted atomic.Bool
+}
+
+func (s *deletableStorage) UpdateStatus(ctx context.Context, url string, st model.Status) (err error) {
+	switch s.deleted.Load() {
+	case true:
+		err = storage.ErrNotFound
+	default:
+		err = s.Storage.UpdateStatus(ctx, url, st)
+	}
+	return
+}
+
+func TestHandler_Handle_Deleted(t *testing.T) {
+	var conns atomic.Int32
+	closeStatus := make(chan websocket.StatusCode, 1)
+	url := newTestServer(t, func(ctx context.Context, conn *websocket.Conn, r *http.Request) {
+		conns.Add(1)
+		go func() {
+			_, _, err := conn.Read(ctx)
+			closeStatus <- websocket.CloseStatus(err)
+		}()
+		ticker := time.NewTicker(50 * time.Millisecond)
+		defer ticker.Stop()
+		for ctx.Err() == nil {
+			if conn.Write(ctx, websocket.MessageText, []byte(`{"text":"hello"}`)) != nil {
+				break
+			}
+			select {
+			case <-ctx.Done():
+			case <-ticker.C:
+			}
+		}
+	})
+	cfgApi, cfgHandler := newTestConfig()
+	// persist the status on every update
+	cfgHandler.Status.Interval = 0
+	svcPub := &pubRecorder{}
+	stor := &deletableStorage{
+		Storage: storage.NewMockStorage(),
+	}
+	conv := converter.NewService("com_awakari_websocket_v1")
+	h := NewFactory(cfgApi, cfgHandler, conv, svcPub, stor, slog.Default())(url, model.Stream{})
+	done := handleAsync(t, h)
+	require.Eventually(t, func() bool {
+		return len(svcPub.texts()) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	stor.deleted.Store(true)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Handle should return when the stream is deleted")
+	}
+	select {
+	case st := <-closeStatus:
+		assert.Equal(t, websocket.StatusNormalClosure, st)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the peer should see the connection closed")
+	}
+	count := len(svcPub.texts())
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, count, len(svcPub.texts()))
+	assert.Equal(t, int32(1), conns.Load())
+}
@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/awakari/source-websocket/model"
+	"github.com/coder/websocket"
+	"time"
+)
+
+type handshakeStep struct {
+	msg     string
+	ack     map[string]any
+	rej     map[string]any
+	timeout time.Duration
+}
+
+var ErrHandshakeTimeout = errors.New("handshake timeout")
+var ErrRejected = errors.New("subscription rejected")
+
+func compileHandshake(src []model.HandshakeStep) (dst []handshakeStep, err error) {
+	for i, s := range src {
+		step := handshakeStep{
+			msg:     s.Message,
+			timeout: s.Timeout,
+		}
+		switch {
+		case s.Message == "":
+			err = errors.New("empty message")
+		case s.Timeout < 0:
+			err = fmt.Errorf("negative timeout %s", s.Timeout)
+		case s.Ack == "" && s.Error != "":
+			err = errors.New("error pattern requires the ack pattern")
+		}
+		if err == nil && s.Ack != "" {
+			step.ack, err = compileMatch(s.Ack)
+		}
+		if err == nil && s.Error != "" {
+			step.rej, err = compileMatch(s.Error)
+		}
+		if err != nil {
+			err = fmt.Errorf("%w: handshake step #%d: %s", ErrInvalid, i, err)
+			break
+		}
+		dst = append(dst, step)
+	}
+	return
+}
+
+// handshake sends the handshake messages in order, waiting for the response where required.
+func (h *handler) handshake(ctx context.Context, conn *websocket.Conn) (err error) {
+	for i, step := range h.handshakeSteps {
+		err = conn.Write(ctx, websocket.MessageText, []byte(step.msg))
+		if err == nil && step.ack != nil {
+			err = h.awaitAck(ctx, conn, step)
+		}
+		if err != nil {
+			err = fmt.Errorf("handshake step #%d: %w", i, err)
+			break
+		}
+	}
+	return
+}
+
+// awaitAck reads the messages until the acknowledgement, the rejection or the timeout. Other messages are answered
+// using the reply rules but never published.
+func (h *handler) awaitAck(ctx context.Context, conn *websocket.Conn, step handshakeStep) (err error) {
+	timeout := step.timeout
+	if timeout == 0 {
+		timeout = h.cfgHandler.Handshake.Timeout
+	}
+	ctxAck, cancel := context.WithTimeoutCause(ctx, timeout, ErrHandshakeTimeout)
+	defer cancel()
+	var acked bool
+	for !acked && err == nil {
+		var msgs []map[string]any
+		msgs, err = h.read(ctxAck, conn)
+		switch {
+		case errors.Is(err, ErrDecode), errors.Is(err, ErrTooLarge):
+			// not a response to wait for, the oversized frame is counted already
+			err = nil
+		case err != nil:
+			if errors.Is(context.Cause(ctxAck), ErrHandshakeTimeout) {
+				err = fmt.Errorf("%w: no response within %s", ErrHandshakeTimeout, timeout)
+			}
+		}
+		for i := 0; i < len(msgs) && !acked && err == nil; i++ {
+			acked, err = h.ack(ctx, conn, step, msgs[i])
+		}
+	}
+	return
+}
+
+func (h *handler) ack(ctx context.Context, conn *websocket.Conn, step handshakeStep, msg map[string]any) (acked bool, err error) {
+	switch {
+	case step.rej != nil && matches(step.rej, msg):
+		data, _ := json.Marshal(msg)
+		err = fmt.Errorf("%w: %s", ErrRejected, data)
+	case matches(step.ack, msg):
+		acked = true
+	default:
+		_, err = h.reply(ctx, conn, msg)
+	}
+	return
+}
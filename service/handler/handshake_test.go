@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"github.com/awakari/source-websocket/model"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestCompileHandshake(t *testing.T) {
+	cases := map[string]struct {
+		in  model.HandshakeStep
+		err error
+	}{
+		"ok": {
+			in: model.HandshakeStep{
+				Message: `{"type":"subscribe","channels":["ticker"]}`,
+				Ack:     `{"type":"subscriptions"}`,
+				Error:   `{"type":"error"}`,
+				Timeout: 5 * time.Second,
+			},
+		},
+		"ok w/o waiting": {
+			in: model.HandshakeStep{
+				Message: `{"op":"auth"}`,
+			},
+		},
+		"empty message": {
+			in: model.HandshakeStep{
+				Ack: `{"type":"subscriptions"}`,
+			},
+			err: ErrInvalid,
+		},
+		"error w/o ack": {
+			in: model.HandshakeStep{
+				Message: `{"op":"auth"}`,
+				Error:   `{"type":"error"}`,
+			},
+			err: ErrInvalid,
+		},
+		"invalid ack": {
+			in: model.HandshakeStep{
+				Message: `{"op":"auth"}`,
+				Ack:     `"ok"`,
+			},
+			err: ErrInvalid,
+		},
+		"negative timeout": {
+			in: model.HandshakeStep{
+				Message: `{"op":"auth"}`,
+				Ack:     `{"type":"subscriptions"}`,
+				Timeout: -time.Second,
+			},
+			err: ErrInvalid,
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			_, err := compileHandshake([]model.HandshakeStep{c.in})
+			assert.ErrorIs(t, err, c.err)
+		})
+	}
+}
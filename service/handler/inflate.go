@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"github.com/awakari/source-websocket/model"
+	"github.com/coder/websocket"
+	"io"
+)
+
+var ErrInflatedTooLarge = errors.New("inflated payload is too large")
+
+// inflating wraps the decoder to decompress the binary frames first.
+func inflating(dec decoder, c model.PayloadCompression, sizeMax int64) (wrapped decoder) {
+	wrapped = dec
+	if c != model.PayloadNone {
+		wrapped = func(typ websocket.MessageType, data []byte) (msgs []map[string]any, err error) {
+			if typ == websocket.MessageBinary {
+				data, err = inflate(c, data, sizeMax)
+				if err != nil {
+					err = fmt.Errorf("%w: %s: %w", ErrDecode, c, err)
+				}
+			}
+			if err == nil {
+				msgs, err = dec(typ, data)
+			}
+			return
+		}
+	}
+	return
+}
+
+// algo is the actual payload compression algorithm, including the ones detected automatically only.
+type algo int
+
+const (
+	algoNone algo = iota
+	algoGzip
+	algoDeflate
+	algoZlib
+)
+
+func inflate(c model.PayloadCompression, data []byte, sizeMax int64) (inflated []byte, err error) {
+	var a algo
+	switch c {
+	case model.PayloadGzip:
+		a = algoGzip
+	case model.PayloadDeflate:
+		a = algoDeflate
+	case model.PayloadAuto:
+		a = detectCompression(data)
+	}
+	var r io.ReadCloser
+	switch a {
+	case algoGzip:
+		r, err = gzip.NewReader(bytes.NewReader(data))
+	case algoDeflate:
+		r = flate.NewReader(bytes.NewReader(data))
+	case algoZlib:
+		r, err = zlib.NewReader(bytes.NewReader(data))
+	default:
+		inflated = data
+	}
+	if err == nil && r != nil {
+		defer r.Close()
+		// read one byte more to detect the excess
+		inflated, err = io.ReadAll(io.LimitReader(r, sizeMax+1))
+		if err == nil && int64(len(inflated)) > sizeMax {
+			err = fmt.Errorf("%w: exceeds %d bytes", ErrInflatedTooLarge, sizeMax)
+		}
+	}
+	return
+}
+
+func detectCompression(data []byte) (a algo) {
+	switch {
+	case len(data) > 1 && data[0] == 0x1f && data[1] == 0x8b:
+		a = algoGzip
+	case len(data) > 1 && data[0]&0x0f == 8 && (uint16(data[0])<<8|uint16(data[1]))%31 == 0:
+		a = algoZlib
+	default:
+		a = algoNone
+	}
+	return
+}
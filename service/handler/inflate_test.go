@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"github.com/awakari/source-websocket/model"
+	"github.com/coder/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"testing"
+)
+
+func TestInflating(t *testing.T) {
+	msg := []byte(`{"ch":"market.btcusdt.ticker","tick":{"close":97123.45}}`)
+	compress := func(newWriter func(w io.Writer) io.WriteCloser) []byte {
+		buf := &bytes.Buffer{}
+		w := newWriter(buf)
+		_, err := w.Write(msg)
+		require.Nil(t, err)
+		require.Nil(t, w.Close())
+		return buf.Bytes()
+	}
+	gzipped := compress(func(w io.Writer) io.WriteCloser {
+		return gzip.NewWriter(w)
+	})
+	deflated := compress(func(w io.Writer) io.WriteCloser {
+		fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+		return fw
+	})
+	zlibbed := compress(func(w io.Writer) io.WriteCloser {
+		return zlib.NewWriter(w)
+	})
+	cases := map[string]struct {
+		c       model.PayloadCompression
+		typ     websocket.MessageType
+		in      []byte
+		sizeMax int64
+		err     error
+	}{
+		"gzip": {
+			c:   model.PayloadGzip,
+			typ: websocket.MessageBinary,
+			in:  gzipped,
+		},
+		"deflate": {
+			c:   model.PayloadDeflate,
+			typ: websocket.MessageBinary,
+			in:  deflated,
+		},
+		"auto gzip": {
+			c:   model.PayloadAuto,
+			typ: websocket.MessageBinary,
+			in:  gzipped,
+		},
+		"auto zlib": {
+			c:   model.PayloadAuto,
+			typ: websocket.MessageBinary,
+			in:  zlibbed,
+		},
+		"auto plain": {
+			c:   model.PayloadAuto,
+			typ: websocket.MessageBinary,
+			in:  msg,
+		},
+		"text frame is not inflated": {
+			c:   model.PayloadGzip,
+			typ: websocket.MessageText,
+			in:  msg,
+		},
+		"not gzipped": {
+			c:   model.PayloadGzip,
+			typ: websocket.MessageBinary,
+			in:  msg,
+			err: ErrDecode,
+		},
+		"too large": {
+			c:       model.PayloadGzip,
+			typ:     websocket.MessageBinary,
+			in:      gzipped,
+			sizeMax: 10,
+			err:     ErrInflatedTooLarge,
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			sizeMax := c.sizeMax
+			if sizeMax == 0 {
+				sizeMax = 1 << 20
+			}
+			msgs, err := inflating(decodeJson, c.c, sizeMax)(c.typ, c.in)
+			assert.ErrorIs(t, err, c.err)
+			if c.err == nil {
+				assert.Equal(t, []map[string]any{
+					{
+						"ch": "market.btcusdt.ticker",
+						"tick": map[string]any{
+							"close": 97123.45,
+						},
+					},
+				}, msgs)
+			}
+		})
+	}
+}
@@ -2,19 +2,36 @@ package handler
 
 import (
 	"context"
+	"errors"
 	"github.com/awakari/source-websocket/model"
 )
 
-type mockHandler struct{}
+type mockHandler struct {
+	url string
+}
 
 var NewMock Factory = func(url string, str model.Stream) Handler {
-	return mockHandler{}
+	return mockHandler{
+		url: url,
+	}
 }
 
-func (m mockHandler) Close() error {
-	return nil
+func (m mockHandler) Close() (err error) {
+	switch m.url {
+	case "close_fail":
+		err = errors.New("close failure")
+	}
+	return
 }
 
 func (m mockHandler) Handle(ctx context.Context) {
 	return
 }
+
+func (m mockHandler) Status() (st model.Status) {
+	switch m.url {
+	case "failed":
+		st.State = model.StateFailed
+	}
+	return
+}
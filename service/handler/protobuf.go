@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"fmt"
+	"github.com/awakari/source-websocket/model"
+	"github.com/coder/websocket"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"time"
+)
+
+var protoTimestampName = (&timestamppb.Timestamp{}).ProtoReflect().Descriptor().FullName()
+
+func newProtobufDecoder(schema model.ProtobufSchema) (dec decoder, err error) {
+	var md protoreflect.MessageDescriptor
+	md, err = protobufMessageDescriptor(schema)
+	if err == nil {
+		dec = func(_ websocket.MessageType, data []byte) (msgs []map[string]any, err error) {
+			msg := dynamicpb.NewMessage(md)
+			err = proto.Unmarshal(data, msg)
+			switch err {
+			case nil:
+				msgs = append(msgs, protobufMessageToMap(msg))
+			default:
+				err = fmt.Errorf("%w: protobuf: %s", ErrDecode, err)
+			}
+			return
+		}
+	}
+	return
+}
+
+func protobufMessageDescriptor(schema model.ProtobufSchema) (md protoreflect.MessageDescriptor, err error) {
+	fds := &descriptorpb.FileDescriptorSet{}
+	err = proto.Unmarshal(schema.Descriptors, fds)
+	var files *protoregistry.Files
+	if err == nil {
+		files, err = protodesc.NewFiles(withWellKnownDeps(fds))
+	}
+	var d protoreflect.Descriptor
+	if err == nil {
+		d, err = files.FindDescriptorByName(protoreflect.FullName(schema.Message))
+	}
+	if err == nil {
+		var ok bool
+		md, ok = d.(protoreflect.MessageDescriptor)
+		if !ok {
+			err = fmt.Errorf("%s is not a message", schema.Message)
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("protobuf schema: %s", err)
+	}
+	return
+}
+
+// withWellKnownDeps adds the missing dependencies known to the binary, e.g. google/protobuf/timestamp.proto, so the
+// descriptor set doesn't have to include them.
+func withWellKnownDeps(fds *descriptorpb.FileDescriptorSet) *descriptorpb.FileDescriptorSet {
+	known := map[string]bool{}
+	for _, f := range fds.File {
+		known[f.GetName()] = true
+	}
+	for _, f := range fds.File {
+		for _, dep := range f.Dependency {
+			if !known[dep] {
+				fd, err := protoregistry.GlobalFiles.FindFileByPath(dep)
+				if err == nil {
+					fds.File = append(fds.File, protodesc.ToFileDescriptorProto(fd))
+					known[dep] = true
+				}
+			}
+		}
+	}
+	return fds
+}
+
+// protobufMessageToMap converts the message to the same tree as the decoded JSON using the proto field names.
+// Unset fields are omitted.
+func protobufMessageToMap(msg protoreflect.Message) (node map[string]any) {
+	node = make(map[string]any)
+	msg.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsList():
+			l := v.List()
+			elems := make([]any, l.Len())
+			for i := range l.Len() {
+				elems[i] = protobufValue(fd, l.Get(i))
+			}
+			node[string(fd.Name())] = elems
+		case fd.IsMap():
+			entries := make(map[string]any)
+			v.Map().Range(func(k protoreflect.MapKey, mv protoreflect.Value) bool {
+				entries[k.String()] = protobufValue(fd.MapValue(), mv)
+				return true
+			})
+			node[string(fd.Name())] = entries
+		default:
+			node[string(fd.Name())] = protobufValue(fd, v)
+		}
+		return true
+	})
+	return
+}
+
+func protobufValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) (n any) {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		switch fd.Message().FullName() {
+		case protoTimestampName:
+			n = protobufTimestamp(v.Message())
+		default:
+			n = protobufMessageToMap(v.Message())
+		}
+	case protoreflect.EnumKind:
+		ev := fd.Enum().Values().ByNumber(v.Enum())
+		switch ev {
+		case nil:
+			n = int64(v.Enum())
+		default:
+			n = string(ev.Name())
+		}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		n = v.Int()
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		n = normalizeUint(v.Uint())
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		n = v.Float()
+	case protoreflect.BoolKind:
+		n = v.Bool()
+	case protoreflect.StringKind:
+		n = v.String()
+	case protoreflect.BytesKind:
+		n = v.Bytes()
+	}
+	return
+}
+
+func protobufTimestamp(msg protoreflect.Message) string {
+	fields := msg.Descriptor().Fields()
+	secs := msg.Get(fields.ByName("seconds")).Int()
+	nanos := msg.Get(fields.ByName("nanos")).Int()
+	return time.Unix(secs, nanos).UTC().Format(time.RFC3339Nano)
+}
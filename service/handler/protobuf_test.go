@@ -0,0 +1,194 @@
+package handler
+
+import (
+	"github.com/awakari/source-websocket/model"
+	"github.com/coder/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"testing"
+	"time"
+)
+
+// tickerDescriptors is the descriptor set of:
+//
+//	syntax = "proto3";
+//	package market;
+//	import "google/protobuf/timestamp.proto";
+//	enum Side { SIDE_UNKNOWN = 0; BUY = 1; SELL = 2; }
+//	message Ticker {
+//	  string product_id = 1;
+//	  double price = 2;
+//	  uint64 sequence = 3;
+//	  google.protobuf.Timestamp time = 4;
+//	  Side side = 5;
+//	  repeated int32 trade_ids = 6;
+//	  map<string, string> labels = 7;
+//	}
+//
+// the timestamp.proto is omitted intentionally, it should be resolved from the known files.
+func tickerDescriptors(t *testing.T) []byte {
+	field := func(name string, num int32, typ descriptorpb.FieldDescriptorProto_Type, label descriptorpb.FieldDescriptorProto_Label, typeName string) *descriptorpb.FieldDescriptorProto {
+		f := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(num),
+			Type:     typ.Enum(),
+			Label:    label.Enum(),
+		}
+		if typeName != "" {
+			f.TypeName = proto.String(typeName)
+		}
+		return f
+	}
+	opt := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+	rep := descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+	fd := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("market/ticker.proto"),
+		Package:    proto.String("market"),
+		Syntax:     proto.String("proto3"),
+		Dependency: []string{"google/protobuf/timestamp.proto"},
+		EnumType: []*descriptorpb.EnumDescriptorProto{
+			{
+				Name: proto.String("Side"),
+				Value: []*descriptorpb.EnumValueDescriptorProto{
+					{Name: proto.String("SIDE_UNKNOWN"), Number: proto.Int32(0)},
+					{Name: proto.String("BUY"), Number: proto.Int32(1)},
+					{Name: proto.String("SELL"), Number: proto.Int32(2)},
+				},
+			},
+		},
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("Ticker"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					field("product_id", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, opt, ""),
+					field("price", 2, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE, opt, ""),
+					field("sequence", 3, descriptorpb.FieldDescriptorProto_TYPE_UINT64, opt, ""),
+					field("time", 4, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, opt, ".google.protobuf.Timestamp"),
+					field("side", 5, descriptorpb.FieldDescriptorProto_TYPE_ENUM, opt, ".market.Side"),
+					field("trade_ids", 6, descriptorpb.FieldDescriptorProto_TYPE_INT32, rep, ""),
+					field("labels", 7, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, rep, ".market.Ticker.LabelsEntry"),
+				},
+				NestedType: []*descriptorpb.DescriptorProto{
+					{
+						Name: proto.String("LabelsEntry"),
+						Field: []*descriptorpb.FieldDescriptorProto{
+							field("key", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, opt, ""),
+							field("value", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING, opt, ""),
+						},
+						Options: &descriptorpb.MessageOptions{
+							MapEntry: proto.Bool(true),
+						},
+					},
+				},
+			},
+		},
+	}
+	data, err := proto.Marshal(&descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{
+			fd,
+		},
+	})
+	require.Nil(t, err)
+	return data
+}
+
+func TestProtobufDecoder(t *testing.T) {
+	schema := model.ProtobufSchema{
+		Descriptors: tickerDescriptors(t),
+		Message:     "market.Ticker",
+	}
+	dec, err := newProtobufDecoder(schema)
+	require.Nil(t, err)
+	// encode the frame using the same descriptor
+	md, err := protobufMessageDescriptor(schema)
+	require.Nil(t, err)
+	msg := dynamicpb.NewMessage(md)
+	fields := md.Fields()
+	msg.Set(fields.ByName("product_id"), protoreflect.ValueOfString("BTC-USD"))
+	msg.Set(fields.ByName("price"), protoreflect.ValueOfFloat64(97123.45))
+	msg.Set(fields.ByName("sequence"), protoreflect.ValueOfUint64(12345))
+	ts := timestamppb.New(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	tsMsg := dynamicpb.NewMessage(fields.ByName("time").Message())
+	tsMsg.Set(tsMsg.Descriptor().Fields().ByName("seconds"), protoreflect.ValueOfInt64(ts.Seconds))
+	msg.Set(fields.ByName("time"), protoreflect.ValueOfMessage(tsMsg))
+	msg.Set(fields.ByName("side"), protoreflect.ValueOfEnum(2))
+	tradeIds := msg.Mutable(fields.ByName("trade_ids")).List()
+	tradeIds.Append(protoreflect.ValueOfInt32(1))
+	tradeIds.Append(protoreflect.ValueOfInt32(2))
+	labels := msg.Mutable(fields.ByName("labels")).Map()
+	labels.Set(protoreflect.ValueOfString("venue").MapKey(), protoreflect.ValueOfString("coinbase"))
+	frame, err := proto.Marshal(msg)
+	require.Nil(t, err)
+	//
+	msgs, err := dec(websocket.MessageBinary, frame)
+	require.Nil(t, err)
+	assert.Equal(t, []map[string]any{
+		{
+			"product_id": "BTC-USD",
+			"price":      97123.45,
+			"sequence":   int64(12345),
+			"time":       "2025-01-01T00:00:00Z",
+			"side":       "SELL",
+			"trade_ids": []any{
+				int64(1),
+				int64(2),
+			},
+			"labels": map[string]any{
+				"venue": "coinbase",
+			},
+		},
+	}, msgs)
+	//
+	_, err = dec(websocket.MessageBinary, []byte{0x0a, 0xff})
+	assert.ErrorIs(t, err, ErrDecode)
+}
+
+func TestNewDecoder_Protobuf(t *testing.T) {
+	cases := map[string]struct {
+		schema model.ProtobufSchema
+		err    error
+	}{
+		"ok": {
+			schema: model.ProtobufSchema{
+				Descriptors: tickerDescriptors(t),
+				Message:     "market.Ticker",
+			},
+		},
+		"missing message": {
+			schema: model.ProtobufSchema{
+				Descriptors: tickerDescriptors(t),
+				Message:     "market.Trade",
+			},
+			err: ErrInvalid,
+		},
+		"not a message": {
+			schema: model.ProtobufSchema{
+				Descriptors: tickerDescriptors(t),
+				Message:     "market.Side",
+			},
+			err: ErrInvalid,
+		},
+		"invalid descriptors": {
+			schema: model.ProtobufSchema{
+				Descriptors: []byte{0x0a, 0xff},
+				Message:     "market.Ticker",
+			},
+			err: ErrInvalid,
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			_, err := newDecoder(model.Stream{
+				Format:   model.FormatProtobuf,
+				Protobuf: c.schema,
+			})
+			assert.ErrorIs(t, err, c.err)
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/awakari/source-websocket/model"
+	"github.com/awakari/source-websocket/service/converter"
+	"reflect"
+	"text/template"
+)
+
+type replyRule struct {
+	match map[string]any
+	tmpl  *template.Template
+}
+
+func compileReplies(src []model.Reply) (dst []replyRule, err error) {
+	for i, r := range src {
+		var rule replyRule
+		rule.match, err = compileMatch(r.Match)
+		if err == nil {
+			rule.tmpl, err = template.New(fmt.Sprintf("reply%d", i)).Funcs(converter.TemplateFuncs).Option("missingkey=error").Parse(r.Template)
+		}
+		if err != nil {
+			err = fmt.Errorf("%w: reply #%d: %s", ErrInvalid, i, err)
+			break
+		}
+		dst = append(dst, rule)
+	}
+	return
+}
+
+// reply finds the first rule matching the message and renders the reply, ok is false when nothing matches.
+func reply(rules []replyRule, msg map[string]any) (data []byte, ok bool, err error) {
+	for _, rule := range rules {
+		if matches(rule.match, msg) {
+			ok = true
+			buf := &bytes.Buffer{}
+			err = rule.tmpl.Execute(buf, msg)
+			data = buf.Bytes()
+			break
+		}
+	}
+	return
+}
+
+// compileMatch parses the JSON object pattern, see the matches func.
+func compileMatch(src string) (pattern map[string]any, err error) {
+	err = json.Unmarshal([]byte(src), &pattern)
+	if err == nil && len(pattern) == 0 {
+		err = fmt.Errorf("empty match")
+	}
+	return
+}
+
+func matches(pattern, msg map[string]any) (ok bool) {
+	ok = true
+	for k, pv := range pattern {
+		var v any
+		v, ok = msg[k]
+		if ok {
+			switch pvt := pv.(type) {
+			case nil:
+			case map[string]any:
+				var vm map[string]any
+				vm, ok = v.(map[string]any)
+				ok = ok && matches(pvt, vm)
+			default:
+				ok = equal(pv, v)
+			}
+		}
+		if !ok {
+			break
+		}
+	}
+	return
+}
+
+// equal compares the numbers by value: the pattern numbers are float64 while the binary formats decode the integers.
+func equal(pv, v any) (ok bool) {
+	pn, pnOk := number(pv)
+	n, nOk := number(v)
+	switch {
+	case pnOk && nOk:
+		ok = pn == n
+	default:
+		ok = reflect.DeepEqual(pv, v)
+	}
+	return
+}
+
+func number(v any) (n float64, ok bool) {
+	ok = true
+	switch vt := v.(type) {
+	case int:
+		n = float64(vt)
+	case int8:
+		n = float64(vt)
+	case int16:
+		n = float64(vt)
+	case int32:
+		n = float64(vt)
+	case int64:
+		n = float64(vt)
+	case uint:
+		n = float64(vt)
+	case uint8:
+		n = float64(vt)
+	case uint16:
+		n = float64(vt)
+	case uint32:
+		n = float64(vt)
+	case uint64:
+		n = float64(vt)
+	case float32:
+		n = float64(vt)
+	case float64:
+		n = vt
+	default:
+		ok = false
+	}
+	return
+}
@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"encoding/json"
+	"github.com/awakari/source-websocket/model"
+	"github.com/coder/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vmihailenco/msgpack/v5"
+	"testing"
+)
+
+func TestReply(t *testing.T) {
+	rules, err := compileReplies([]model.Reply{
+		{
+			Match:    `{"ping":null}`,
+			Template: `{"pong":{{json .ping}}}`,
+		},
+		{
+			Match:    `{"event":"heartbeat","data":{"ack":true}}`,
+			Template: `{"event":"heartbeat","id":"{{.id}}"}`,
+		},
+		{
+			Match:    `{"op":10}`,
+			Template: `{"op":1,"d":{{json .s}}}`,
+		},
+	})
+	require.Nil(t, err)
+	cases := map[string]struct {
+		in     string
+		format model.Format
+		out    string
+		ok     bool
+		err    bool
+		rules  []replyRule
+	}{
+		"ping": {
+			in:  `{"ping":1735689600123}`,
+			out: `{"pong":1735689600123}`,
+			ok:  true,
+		},
+		"nested match": {
+			in:  `{"event":"heartbeat","id":"abc","data":{"ack":true,"n":1}}`,
+			out: `{"event":"heartbeat","id":"abc"}`,
+			ok:  true,
+		},
+		"nested mismatch": {
+			in: `{"event":"heartbeat","id":"abc","data":{"ack":false}}`,
+		},
+		"no match": {
+			in: `{"price":"123.45"}`,
+		},
+		"number": {
+			in:  `{"op":10,"s":42}`,
+			out: `{"op":1,"d":42}`,
+			ok:  true,
+		},
+		"msgpack number": {
+			in:     `{"op":10,"s":42}`,
+			format: model.FormatMsgpack,
+			out:    `{"op":1,"d":42}`,
+			ok:     true,
+		},
+		"msgpack number mismatch": {
+			in:     `{"op":11,"s":42}`,
+			format: model.FormatMsgpack,
+		},
+		"missing key in template": {
+			in:  `{"event":"heartbeat","data":{"ack":true}}`,
+			ok:  true,
+			err: true,
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			var msg map[string]any
+			require.Nil(t, json.Unmarshal([]byte(c.in), &msg))
+			if c.format == model.FormatMsgpack {
+				data, err := msgpack.Marshal(map[string]any{
+					"op": int64(msg["op"].(float64)),
+					"s":  int64(msg["s"].(float64)),
+				})
+				require.Nil(t, err)
+				msgs, err := decodeMsgpack(websocket.MessageBinary, data)
+				require.Nil(t, err)
+				msg = msgs[0]
+			}
+			out, ok, err := reply(rules, msg)
+			assert.Equal(t, c.ok, ok)
+			if c.err {
+				assert.Error(t, err)
+			} else {
+				assert.Nil(t, err)
+				assert.Equal(t, c.out, string(out))
+			}
+		})
+	}
+}
+
+func TestCompileReplies(t *testing.T) {
+	cases := map[string]struct {
+		in  model.Reply
+		err error
+	}{
+		"ok": {
+			in: model.Reply{
+				Match:    `{"op":"ping"}`,
+				Template: `{"op":"pong"}`,
+			},
+		},
+		"match is not an object": {
+			in: model.Reply{
+				Match: `"ping"`,
+			},
+			err: ErrInvalid,
+		},
+		"empty match": {
+			in: model.Reply{
+				Match: `{}`,
+			},
+			err: ErrInvalid,
+		},
+		"invalid template": {
+			in: model.Reply{
+				Match:    `{"op":"ping"}`,
+				Template: `{{.op`,
+			},
+			err: ErrInvalid,
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			_, err := compileReplies([]model.Reply{c.in})
+			assert.ErrorIs(t, err, c.err)
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/awakari/source-websocket/model"
+	"github.com/awakari/source-websocket/service/converter"
+	"github.com/awakari/source-websocket/storage"
+	"github.com/coder/websocket"
+	"time"
+)
+
+func (h *handler) Status() (st model.Status) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	st = h.status
+	return
+}
+
+func (h *handler) setState(state model.State) {
+	h.updateStatus(func(st *model.Status, t time.Time) {
+		st.State = state
+	})
+}
+
+func (h *handler) setConnected(subprotocol string) {
+	h.updateStatus(func(st *model.Status, t time.Time) {
+		st.State = model.StateSubscribed
+		st.ConnectedAt = t
+		st.Subprotocol = subprotocol
+	})
+}
+
+func (h *handler) setError(state model.State, err error) {
+	h.updateStatus(func(st *model.Status, t time.Time) {
+		st.State = state
+		st.LastError = err.Error()
+		st.LastErrorAt = t
+		st.CountErrors++
+	})
+}
+
+func (h *handler) countMessage() {
+	h.updateStatus(func(st *model.Status, t time.Time) {
+		st.State = model.StateReceiving
+		st.LastMessageAt = t
+		st.CountMessages++
+	})
+}
+
+func (h *handler) setPong(rtt time.Duration) {
+	h.updateStatus(func(st *model.Status, t time.Time) {
+		st.PongAt = t
+		st.PingRtt = rtt
+	})
+}
+
+func (h *handler) countIdleTimeout() {
+	h.updateStatus(func(st *model.Status, t time.Time) {
+		st.CountIdleTimeouts++
+	})
+}
+
+func (h *handler) countOversized() {
+	h.updateStatus(func(st *model.Status, t time.Time) {
+		st.State = model.StateReceiving
+		st.LastMessageAt = t
+		st.CountOversized++
+	})
+}
+
+func (h *handler) countSkipped(skipped converter.Skipped) {
+	h.updateStatus(func(st *model.Status, t time.Time) {
+		st.CountControl += uint64(skipped.Control)
+		st.CountUnknown += uint64(skipped.Unknown)
+		st.CountRejected += uint64(skipped.Rejected)
+	})
+}
+
+func (h *handler) countEvent() {
+	h.updateStatus(func(st *model.Status, t time.Time) {
+		st.CountEvents++
+	})
+}
+
+// updateStatus applies the change to the in-memory status and persists it when the state changes or when it was not
+// persisted for longer than the configured interval.
+func (h *handler) updateStatus(change func(st *model.Status, t time.Time)) {
+	t := time.Now().UTC()
+	h.lock.Lock()
+	statePrev := h.status.State
+	change(&h.status, t)
+	persist := h.status.State != statePrev || t.Sub(h.statusSavedAt) >= h.cfgHandler.Status.Interval
+	if persist {
+		h.status.UpdatedAt = t
+		h.statusSavedAt = t
+	}
+	st := h.status
+	h.lock.Unlock()
+	if persist {
+		ctx, cancel := context.WithTimeout(context.Background(), h.cfgHandler.Status.Timeout)
+		defer cancel()
+		err := h.stor.UpdateStatus(ctx, h.url, st)
+		switch {
+		case err == nil:
+		case errors.Is(err, storage.ErrNotFound):
+			// the stream is deleted, possibly via another replica, so it should not be handled anymore
+			h.stopDeleted()
+		default:
+			h.log.Warn(fmt.Sprintf("failed to update the status of the stream %s: %s", h.url, err))
+		}
+	}
+}
+
+// stopDeleted stops the handler like Close does but w/o waiting for the Handle loop, because it is called from there.
+func (h *handler) stopDeleted() {
+	if h.stopCtx.Err() != nil {
+		return
+	}
+	h.log.Info(fmt.Sprintf("the stream %s is deleted, stopping", h.url))
+	h.stop()
+	h.lock.Lock()
+	conn := h.conn
+	h.lock.Unlock()
+	if conn != nil {
+		_ = conn.Close(websocket.StatusNormalClosure, "")
+	}
+}
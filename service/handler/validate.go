@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"github.com/awakari/source-websocket/config"
+	"github.com/awakari/source-websocket/model"
+	"github.com/awakari/source-websocket/service/converter"
+	"slices"
+	"strings"
+	"time"
+)
+
+var ErrInvalid = errors.New("invalid stream")
+
+const heartbeatIntervalMin = time.Second
+
+// Validate checks the stream settings the handler depends on. The script top-level statements are executed within the
+// same limits as the handler uses.
+func Validate(str model.Stream, cfgHandler config.HandlerConfig) (err error) {
+	_, err = newDecoder(str)
+	if err == nil {
+		err = validateCompression(str.Compression)
+	}
+	if err == nil && !converter.Supported(str.Converter) {
+		err = fmt.Errorf("%w: unknown converter %q", ErrInvalid, str.Converter)
+	}
+	if err == nil && str.ReadLimit < 0 {
+		err = fmt.Errorf("%w: negative read limit %d", ErrInvalid, str.ReadLimit)
+	}
+	if err == nil && str.Split != "" && slices.Contains(strings.Split(str.Split, "."), "") {
+		err = fmt.Errorf("%w: split path %q contains an empty key", ErrInvalid, str.Split)
+	}
+	for i, hb := range str.Heartbeats {
+		if err != nil {
+			break
+		}
+		switch {
+		case hb.Interval < heartbeatIntervalMin:
+			err = fmt.Errorf("%w: heartbeat #%d interval %s is less than %s", ErrInvalid, i, hb.Interval, heartbeatIntervalMin)
+		case hb.Message == "":
+			err = fmt.Errorf("%w: heartbeat #%d message is empty", ErrInvalid, i)
+		}
+	}
+	if err == nil {
+		_, err = converter.CompileMappings(str.Mappings)
+		if err != nil {
+			err = fmt.Errorf("%w: %w", ErrInvalid, err)
+		}
+	}
+	if err == nil {
+		_, err = converter.CompileTemplate(str.Template)
+		if err != nil {
+			err = fmt.Errorf("%w: %w", ErrInvalid, err)
+		}
+	}
+	if err == nil {
+		_, err = compileFilter(str.Filter)
+	}
+	if err == nil {
+		_, err = converter.CompileScript(str.Script, scriptLimits(cfgHandler))
+		if err != nil {
+			err = fmt.Errorf("%w: %w", ErrInvalid, err)
+		}
+	}
+	if err == nil {
+		_, err = compileReplies(str.Replies)
+	}
+	if err == nil {
+		_, err = compileHandshake(str.Handshake)
+	}
+	return
+}
+
+func validateCompression(c model.Compression) (err error) {
+	switch {
+	case c.Deflate < model.DeflateDisabled || c.Deflate > model.DeflateNoContextTakeover:
+		err = fmt.Errorf("%w: unknown deflate mode %d", ErrInvalid, c.Deflate)
+	case c.Payload < model.PayloadNone || c.Payload > model.PayloadAuto:
+		err = fmt.Errorf("%w: unknown payload compression %d", ErrInvalid, c.Payload)
+	}
+	return
+}
@@ -6,7 +6,6 @@ import (
 	"github.com/awakari/source-websocket/model"
 	"github.com/awakari/source-websocket/util"
 	"log/slog"
-	"time"
 )
 
 type logging struct {
@@ -21,9 +20,9 @@ func NewServiceLogging(svc Service, log *slog.Logger) Service {
 	}
 }
 
-func (l logging) Create(ctx context.Context, url, auth, groupId, userId string, at time.Time) (err error) {
-	err = l.svc.Create(ctx, url, auth, groupId, userId, at)
-	l.log.Log(context.TODO(), util.LogLevel(err), fmt.Sprintf("service.Create(%s, %s, %s, %s, %s): %s", url, auth, groupId, userId, at, err))
+func (l logging) Create(ctx context.Context, url string, str model.Stream) (err error) {
+	err = l.svc.Create(ctx, url, str)
+	l.log.Log(context.TODO(), util.LogLevel(err), fmt.Sprintf("service.Create(%s, %+v): %s", url, str, err))
 	return
 }
 
@@ -39,6 +38,12 @@ func (l logging) Delete(ctx context.Context, url, groupId, userId string) (err e
 	return
 }
 
+func (l logging) Resume(ctx context.Context, url, groupId, userId string) (err error) {
+	err = l.svc.Resume(ctx, url, groupId, userId)
+	l.log.Log(context.TODO(), util.LogLevel(err), fmt.Sprintf("service.Resume(%s, %s/%s): %s", url, groupId, userId, err))
+	return
+}
+
 func (l logging) List(ctx context.Context, limit uint32, filter model.Filter, order model.Order, cursor string) (urls []string, err error) {
 	urls, err = l.svc.List(ctx, limit, filter, order, cursor)
 	l.log.Log(context.TODO(), util.LogLevel(err), fmt.Sprintf("service.List(%d, %+v, %+v, %s): %d, %s", limit, filter, order, cursor, len(urls), err))
@@ -13,12 +13,14 @@ func NewServiceMock() Service {
 	return mock{}
 }
 
-func (m mock) Create(ctx context.Context, url, auth, groupId, userId string, at time.Time) (err error) {
+func (m mock) Create(ctx context.Context, url string, str model.Stream) (err error) {
 	switch url {
 	case "fail":
 		err = ErrUnexpected
 	case "conflict":
 		err = ErrConflict
+	case "invalid":
+		err = ErrInvalid
 	}
 	return
 }
@@ -34,6 +36,26 @@ func (m mock) Read(ctx context.Context, url string) (str model.Stream, err error
 		str.UserId = "user1"
 		str.CreatedAt = time.Date(2024, 11, 4, 14, 52, 0, 0, time.UTC)
 		str.Replica = 1
+		str.Status = model.Status{
+			State:         model.StateReceiving,
+			UpdatedAt:     time.Date(2024, 11, 4, 14, 53, 0, 0, time.UTC),
+			ConnectedAt:   time.Date(2024, 11, 4, 14, 52, 1, 0, time.UTC),
+			LastMessageAt: time.Date(2024, 11, 4, 14, 53, 0, 0, time.UTC),
+			CountMessages: 42,
+			CountEvents:   41,
+			CountErrors:   1,
+		}
+		str.Headers = []model.Header{
+			{
+				Name:   "Authorization",
+				Value:  "Bearer token0",
+				Secret: true,
+			},
+			{
+				Name:  "Origin",
+				Value: "https://awakari.com",
+			},
+		}
 	}
 	return
 }
@@ -48,6 +70,20 @@ func (m mock) Delete(ctx context.Context, url, groupId, userId string) (err erro
 	return
 }
 
+func (m mock) Resume(ctx context.Context, url, groupId, userId string) (err error) {
+	switch url {
+	case "missing":
+		err = ErrNotFound
+	case "fail":
+		err = ErrUnexpected
+	case "running":
+		err = ErrConflict
+	case "other_replica":
+		err = ErrPrecondition
+	}
+	return
+}
+
 func (m mock) List(ctx context.Context, limit uint32, filter model.Filter, order model.Order, cursor string) (urls []string, err error) {
 	switch cursor {
 	case "fail":
@@ -4,17 +4,19 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/awakari/source-websocket/config"
 	"github.com/awakari/source-websocket/model"
 	"github.com/awakari/source-websocket/service/handler"
 	"github.com/awakari/source-websocket/storage"
 	"sync"
-	"time"
 )
 
 type Service interface {
-	Create(ctx context.Context, url, sub, groupId, userId string, at time.Time) (err error)
+	Create(ctx context.Context, url string, str model.Stream) (err error)
 	Read(ctx context.Context, url string) (str model.Stream, err error)
 	Delete(ctx context.Context, url, groupId, userId string) (err error)
+	// Resume restarts the failed stream handler, if it is handled by this replica.
+	Resume(ctx context.Context, url, groupId, userId string) (err error)
 	List(ctx context.Context, limit uint32, filter model.Filter, order model.Order, cursor string) (urls []string, err error)
 }
 
@@ -24,11 +26,14 @@ type svc struct {
 	handlersLock   *sync.Mutex
 	handlerByUrl   map[string]handler.Handler
 	handlerFactory handler.Factory
+	cfgHandler     config.HandlerConfig
 }
 
 var ErrNotFound = errors.New("not found")
 var ErrConflict = errors.New("conflict")
 var ErrUnexpected = errors.New("unexpected")
+var ErrInvalid = errors.New("invalid")
+var ErrPrecondition = errors.New("precondition failed")
 
 func NewService(
 	stor storage.Storage,
@@ -36,6 +41,7 @@ func NewService(
 	handlersLock *sync.Mutex,
 	handlerByUrl map[string]handler.Handler,
 	handlerFactory handler.Factory,
+	cfgHandler config.HandlerConfig,
 ) Service {
 	return svc{
 		stor:           stor,
@@ -43,18 +49,17 @@ func NewService(
 		handlersLock:   handlersLock,
 		handlerByUrl:   handlerByUrl,
 		handlerFactory: handlerFactory,
+		cfgHandler:     cfgHandler,
 	}
 }
 
-func (s svc) Create(ctx context.Context, url, sub, groupId, userId string, at time.Time) (err error) {
-	str := model.Stream{
-		Request:   sub,
-		GroupId:   groupId,
-		UserId:    userId,
-		CreatedAt: at,
-		Replica:   s.replicaIndex,
+func (s svc) Create(ctx context.Context, url string, str model.Stream) (err error) {
+	str.Replica = s.replicaIndex
+	err = validate(str, s.cfgHandler)
+	if err == nil {
+		err = s.stor.Create(ctx, url, str)
+		err = translateError(err)
 	}
-	err = s.stor.Create(ctx, url, str)
 	if err == nil {
 		s.handlersLock.Lock()
 		defer s.handlersLock.Unlock()
@@ -62,12 +67,20 @@ func (s svc) Create(ctx context.Context, url, sub, groupId, userId string, at ti
 		s.handlerByUrl[url] = h
 		go h.Handle(context.Background())
 	}
-	err = translateError(err)
 	return
 }
 
 func (s svc) Read(ctx context.Context, url string) (str model.Stream, err error) {
 	str, err = s.stor.Read(ctx, url)
+	if err == nil {
+		// the persisted status may be behind the actual one when the stream is handled by this replica
+		s.handlersLock.Lock()
+		defer s.handlersLock.Unlock()
+		h, hOk := s.handlerByUrl[url]
+		if hOk {
+			str.Status = h.Status()
+		}
+	}
 	err = translateError(err)
 	return
 }
@@ -76,11 +89,48 @@ func (s svc) Delete(ctx context.Context, url, groupId, userId string) (err error
 	err = s.stor.Delete(ctx, url, groupId, userId)
 	if err == nil {
 		s.handlersLock.Lock()
-		defer s.handlersLock.Unlock()
 		h, hOk := s.handlerByUrl[url]
+		delete(s.handlerByUrl, url)
+		s.handlersLock.Unlock()
+		// closing waits for the pending publishing, so it's done w/o holding the lock; the stream is deleted anyway,
+		// hence the connection closing failure doesn't matter to the client
 		if hOk {
-			err = h.Close()
+			_ = h.Close()
+		}
+	}
+	err = translateError(err)
+	return
+}
+
+func (s svc) Resume(ctx context.Context, url, groupId, userId string) (err error) {
+	var str model.Stream
+	str, err = s.stor.Read(ctx, url)
+	if err == nil && (str.GroupId != groupId || str.UserId != userId) {
+		err = fmt.Errorf("%w by url %s", storage.ErrNotFound, url)
+	}
+	var hFailed, h handler.Handler
+	if err == nil {
+		s.handlersLock.Lock()
+		var hOk bool
+		hFailed, hOk = s.handlerByUrl[url]
+		switch {
+		case str.Replica != s.replicaIndex:
+			err = fmt.Errorf("%w: %s is handled by the replica #%d, not #%d", ErrPrecondition, url, str.Replica, s.replicaIndex)
+		case !hOk:
+			err = fmt.Errorf("%w: no handler for %s in the replica #%d", storage.ErrNotFound, url, s.replicaIndex)
+		case hFailed.Status().State != model.StateFailed:
+			err = fmt.Errorf("%w: handler for %s is not failed", storage.ErrConflict, url)
+		default:
+			h = s.handlerFactory(url, str)
+			s.handlerByUrl[url] = h
 		}
+		s.handlersLock.Unlock()
+	}
+	if h != nil {
+		// the failed handler is done with the stream but still holds the resources, closing it is done w/o holding the
+		// lock like in Delete
+		_ = hFailed.Close()
+		go h.Handle(context.Background())
 	}
 	err = translateError(err)
 	return
@@ -94,10 +144,14 @@ func (s svc) List(ctx context.Context, limit uint32, filter model.Filter, order
 
 func translateError(src error) (dst error) {
 	switch {
+	case errors.Is(src, ErrPrecondition):
+		dst = src
 	case errors.Is(src, storage.ErrConflict):
 		dst = fmt.Errorf("%w: %s", ErrConflict, src)
 	case errors.Is(src, storage.ErrNotFound):
 		dst = fmt.Errorf("%w: %s", ErrNotFound, src)
+	case errors.Is(src, storage.ErrSecret):
+		dst = fmt.Errorf("%w: %s", ErrPrecondition, src)
 	case src != nil:
 		dst = fmt.Errorf("%w: %s", ErrUnexpected, src)
 	}
@@ -2,32 +2,305 @@ package service
 
 import (
 	"context"
+	"github.com/awakari/source-websocket/api/http/pub"
+	"github.com/awakari/source-websocket/config"
 	"github.com/awakari/source-websocket/model"
+	"github.com/awakari/source-websocket/service/converter"
 	"github.com/awakari/source-websocket/service/handler"
 	"github.com/awakari/source-websocket/storage"
+	"github.com/coder/websocket"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
+func newTestCfgHandler() (cfgHandler config.HandlerConfig) {
+	cfgHandler.Script.StepsMax = 100_000
+	cfgHandler.Script.MemoryMax = 16 << 20
+	cfgHandler.Script.InputMax = 10_000
+	cfgHandler.Script.EventsMax = 100
+	cfgHandler.Script.AttrsMax = 100
+	cfgHandler.Script.StringLenMax = 64 << 10
+	return
+}
+
 func TestService_Create(t *testing.T) {
 	handlerByUrl := make(map[string]handler.Handler)
-	s := NewService(storage.NewMockStorage(), 1, &sync.Mutex{}, handlerByUrl, handler.NewMock)
+	s := NewService(storage.NewMockStorage(), 1, &sync.Mutex{}, handlerByUrl, handler.NewMock, newTestCfgHandler())
 	s = NewServiceLogging(s, slog.Default())
 	cases := map[string]struct {
 		url          string
-		sub          string
-		groupId      string
-		userId       string
-		at           time.Time
+		str          model.Stream
 		handlerCount int
 		err          error
 	}{
 		"ok": {
 			handlerCount: 1,
 		},
+		"ok w/ headers": {
+			str: model.Stream{
+				Headers: []model.Header{
+					{
+						Name:   "Authorization",
+						Value:  "Bearer token0",
+						Secret: true,
+					},
+					{
+						Name:  "Origin",
+						Value: "https://example.com",
+					},
+				},
+			},
+			handlerCount: 1,
+		},
+		"invalid header name": {
+			str: model.Stream{
+				Headers: []model.Header{
+					{
+						Name: "X Api Key",
+					},
+				},
+			},
+			err: ErrInvalid,
+		},
+		"reserved header": {
+			str: model.Stream{
+				Headers: []model.Header{
+					{
+						Name:  "sec-websocket-key",
+						Value: "foo",
+					},
+				},
+			},
+			err: ErrInvalid,
+		},
+		"ok w/ subprotocols": {
+			str: model.Stream{
+				Subprotocols: []string{
+					"graphql-transport-ws",
+					"v12.stomp",
+				},
+			},
+			handlerCount: 1,
+		},
+		"invalid subprotocol": {
+			str: model.Stream{
+				Subprotocols: []string{
+					"graphql ws",
+				},
+			},
+			err: ErrInvalid,
+		},
+		"ok w/ keepalive": {
+			str: model.Stream{
+				Keepalive: model.Keepalive{
+					PingInterval: 20 * time.Second,
+					IdleTimeout:  -1,
+				},
+			},
+			handlerCount: 1,
+		},
+		"too frequent pings": {
+			str: model.Stream{
+				Keepalive: model.Keepalive{
+					PingInterval: time.Millisecond,
+				},
+			},
+			err: ErrInvalid,
+		},
+		"ok w/ heartbeat and reply": {
+			str: model.Stream{
+				Heartbeats: []model.Heartbeat{
+					{
+						Interval: 30 * time.Second,
+						Message:  `{"op":"ping"}`,
+					},
+				},
+				Replies: []model.Reply{
+					{
+						Match:    `{"ping":null}`,
+						Template: `{"pong":{{json .ping}}}`,
+					},
+				},
+			},
+			handlerCount: 1,
+		},
+		"empty heartbeat message": {
+			str: model.Stream{
+				Heartbeats: []model.Heartbeat{
+					{
+						Interval: 30 * time.Second,
+					},
+				},
+			},
+			err: ErrInvalid,
+		},
+		"invalid reply template": {
+			str: model.Stream{
+				Replies: []model.Reply{
+					{
+						Match:    `{"ping":null}`,
+						Template: `{"pong":{{json .ping}`,
+					},
+				},
+			},
+			err: ErrInvalid,
+		},
+		"ok w/ handshake": {
+			str: model.Stream{
+				Handshake: []model.HandshakeStep{
+					{
+						Message: `{"type":"subscribe","channels":["ticker"]}`,
+						Ack:     `{"type":"subscriptions"}`,
+						Error:   `{"type":"error"}`,
+					},
+				},
+			},
+			handlerCount: 1,
+		},
+		"invalid handshake": {
+			str: model.Stream{
+				Handshake: []model.HandshakeStep{
+					{
+						Message: `{"type":"subscribe","channels":["ticker"]}`,
+						Error:   `{"type":"error"}`,
+					},
+				},
+			},
+			err: ErrInvalid,
+		},
+		"ok w/ lines format": {
+			str: model.Stream{
+				Format: model.FormatLines,
+			},
+			handlerCount: 1,
+		},
+		"ok w/ compression": {
+			str: model.Stream{
+				Compression: model.Compression{
+					Deflate: model.DeflateContextTakeover,
+					Payload: model.PayloadGzip,
+				},
+			},
+			handlerCount: 1,
+		},
+		"unknown payload compression": {
+			str: model.Stream{
+				Compression: model.Compression{
+					Payload: 42,
+				},
+			},
+			err: ErrInvalid,
+		},
+		"negative read limit": {
+			str: model.Stream{
+				ReadLimit: -1,
+			},
+			err: ErrInvalid,
+		},
+		"invalid split path": {
+			str: model.Stream{
+				Split: "data..trades",
+			},
+			err: ErrInvalid,
+		},
+		"ok w/ converter": {
+			str: model.Stream{
+				Converter: "coinbase",
+			},
+			handlerCount: 1,
+		},
+		"unknown converter": {
+			str: model.Stream{
+				Converter: "foo",
+			},
+			err: ErrInvalid,
+		},
+		"ok w/ mappings": {
+			str: model.Stream{
+				Mappings: []model.Mapping{
+					{
+						Path:      "data.mag",
+						Attribute: "magnitude",
+						Type:      model.MappingString,
+						Label:     "Magnitude",
+					},
+					{
+						Path:      "data.tags",
+						Attribute: "tags",
+						Type:      model.MappingJoined,
+						Param:     ",",
+					},
+				},
+			},
+			handlerCount: 1,
+		},
+		"invalid mapping attribute": {
+			str: model.Stream{
+				Mappings: []model.Mapping{
+					{
+						Path:      "data.mag",
+						Attribute: "Magnitude",
+					},
+				},
+			},
+			err: ErrInvalid,
+		},
+		"ok w/ filter": {
+			str: model.Stream{
+				Filter: `has(attrs.magnitude) && double(attrs.magnitude) >= 5.0`,
+			},
+			handlerCount: 1,
+		},
+		"filter not bool": {
+			str: model.Stream{
+				Filter: `size(attrs)`,
+			},
+			err: ErrInvalid,
+		},
+		"ok w/ script": {
+			str: model.Stream{
+				Script: "def transform(msg):\n    return {\"attrs\": {\"magnitude\": msg[\"mag\"]}}\n",
+			},
+			handlerCount: 1,
+		},
+		"script syntax error": {
+			str: model.Stream{
+				Script: "def transform(msg)\n    return None\n",
+			},
+			err: ErrInvalid,
+		},
+		"script allocates too much": {
+			str: model.Stream{
+				Script: "x = \"a\" * 1000000000\n\ndef transform(msg):\n    return None\n",
+			},
+			err: ErrInvalid,
+		},
+		"ok w/ template": {
+			str: model.Stream{
+				Template: "{{with .attrs.magnitude}}Magnitude: {{.}}{{end}}",
+			},
+			handlerCount: 1,
+		},
+		"invalid template": {
+			str: model.Stream{
+				Template: "{{with .attrs.magnitude}}Magnitude: {{.}}",
+			},
+			err: ErrInvalid,
+		},
+		"unknown format": {
+			str: model.Stream{
+				Format: 42,
+			},
+			err: ErrInvalid,
+		},
 		"fail": {
 			url: "fail",
 			err: ErrUnexpected,
@@ -36,10 +309,23 @@ func TestService_Create(t *testing.T) {
 			url: "conflict",
 			err: ErrConflict,
 		},
+		"no secret key": {
+			url: "no_secret_key",
+			str: model.Stream{
+				Headers: []model.Header{
+					{
+						Name:   "Authorization",
+						Value:  "Bearer token0",
+						Secret: true,
+					},
+				},
+			},
+			err: ErrPrecondition,
+		},
 	}
 	for k, c := range cases {
 		t.Run(k, func(t *testing.T) {
-			err := s.Create(context.TODO(), c.url, c.sub, c.groupId, c.userId, c.at)
+			err := s.Create(context.TODO(), c.url, c.str)
 			assert.ErrorIs(t, err, c.err)
 			assert.Equal(t, c.handlerCount, len(handlerByUrl))
 			clear(handlerByUrl)
@@ -48,7 +334,7 @@ func TestService_Create(t *testing.T) {
 }
 
 func TestService_Read(t *testing.T) {
-	s := NewService(storage.NewMockStorage(), 1, &sync.Mutex{}, make(map[string]handler.Handler), handler.NewMock)
+	s := NewService(storage.NewMockStorage(), 1, &sync.Mutex{}, make(map[string]handler.Handler), handler.NewMock, newTestCfgHandler())
 	s = NewServiceLogging(s, slog.Default())
 	cases := map[string]struct {
 		url string
@@ -61,6 +347,15 @@ func TestService_Read(t *testing.T) {
 				UserId:    "user1",
 				CreatedAt: time.Date(2024, 11, 4, 14, 52, 0, 0, time.UTC),
 				Replica:   1,
+				Status: model.Status{
+					State:         model.StateReceiving,
+					UpdatedAt:     time.Date(2024, 11, 4, 14, 53, 0, 0, time.UTC),
+					ConnectedAt:   time.Date(2024, 11, 4, 14, 52, 1, 0, time.UTC),
+					LastMessageAt: time.Date(2024, 11, 4, 14, 53, 0, 0, time.UTC),
+					CountMessages: 42,
+					CountEvents:   41,
+					CountErrors:   1,
+				},
 			},
 		},
 		"fail": {
@@ -82,34 +377,109 @@ func TestService_Read(t *testing.T) {
 }
 
 func TestService_Delete(t *testing.T) {
-	s := NewService(storage.NewMockStorage(), 1, &sync.Mutex{}, make(map[string]handler.Handler), handler.NewMock)
+	handlerByUrl := make(map[string]handler.Handler)
+	s := NewService(storage.NewMockStorage(), 1, &sync.Mutex{}, handlerByUrl, handler.NewMock, newTestCfgHandler())
 	s = NewServiceLogging(s, slog.Default())
 	cases := map[string]struct {
-		url     string
-		groupId string
-		userId  string
-		err     error
+		url          string
+		groupId      string
+		userId       string
+		handlerCount int
+		err          error
 	}{
 		"ok": {},
 		"fail": {
-			url: "fail",
-			err: ErrUnexpected,
+			url:          "fail",
+			handlerCount: 1,
+			err:          ErrUnexpected,
+		},
+		"missing": {
+			url:          "missing",
+			handlerCount: 1,
+			err:          ErrNotFound,
+		},
+		"close failure ignored": {
+			url: "close_fail",
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			handlerByUrl[c.url] = handler.NewMock(c.url, model.Stream{})
+			err := s.Delete(context.TODO(), c.url, c.groupId, c.userId)
+			assert.ErrorIs(t, err, c.err)
+			assert.Equal(t, c.handlerCount, len(handlerByUrl))
+			clear(handlerByUrl)
+		})
+	}
+}
+
+func TestService_Resume(t *testing.T) {
+	handlerByUrl := make(map[string]handler.Handler)
+	s := NewService(storage.NewMockStorage(), 1, &sync.Mutex{}, handlerByUrl, handler.NewMock, newTestCfgHandler())
+	s = NewServiceLogging(s, slog.Default())
+	cases := map[string]struct {
+		url      string
+		groupId  string
+		userId   string
+		handlers []string
+		err      error
+	}{
+		"ok": {
+			url:      "failed",
+			groupId:  "group0",
+			userId:   "user1",
+			handlers: []string{"failed"},
+		},
+		"running": {
+			url:      "url0",
+			groupId:  "group0",
+			userId:   "user1",
+			handlers: []string{"url0"},
+			err:      ErrConflict,
+		},
+		"no handler": {
+			url:     "url0",
+			groupId: "group0",
+			userId:  "user1",
+			err:     ErrNotFound,
+		},
+		"another replica": {
+			url:      "other_replica",
+			groupId:  "group0",
+			userId:   "user1",
+			handlers: []string{"other_replica"},
+			err:      ErrPrecondition,
+		},
+		"another owner": {
+			url:      "failed",
+			groupId:  "group0",
+			userId:   "user2",
+			handlers: []string{"failed"},
+			err:      ErrNotFound,
 		},
 		"missing": {
 			url: "missing",
 			err: ErrNotFound,
 		},
+		"fail": {
+			url: "fail",
+			err: ErrUnexpected,
+		},
 	}
 	for k, c := range cases {
 		t.Run(k, func(t *testing.T) {
-			err := s.Delete(context.TODO(), c.url, c.groupId, c.userId)
+			for _, url := range c.handlers {
+				handlerByUrl[url] = handler.NewMock(url, model.Stream{})
+			}
+			err := s.Resume(context.TODO(), c.url, c.groupId, c.userId)
 			assert.ErrorIs(t, err, c.err)
+			clear(handlerByUrl)
 		})
 	}
 }
 
 func TestService_List(t *testing.T) {
-	s := NewService(storage.NewMockStorage(), 1, &sync.Mutex{}, make(map[string]handler.Handler), handler.NewMock)
+	s := NewService(storage.NewMockStorage(), 1, &sync.Mutex{}, make(map[string]handler.Handler), handler.NewMock, newTestCfgHandler())
 	s = NewServiceLogging(s, slog.Default())
 	cases := map[string]struct {
 		limit  uint32
@@ -138,3 +508,51 @@ func TestService_List(t *testing.T) {
 		})
 	}
 }
+
+func TestService_Resume_Restarts(t *testing.T) {
+	var accepting atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !accepting.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		conn, err := websocket.Accept(w, r, nil)
+		if err == nil {
+			defer conn.CloseNow()
+			_, _, _ = conn.Read(r.Context())
+		}
+	}))
+	t.Cleanup(srv.Close)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	var cfgApi config.ApiConfig
+	var cfgHandler config.HandlerConfig
+	cfgHandler.Backoff.MaxElapsed = 100 * time.Millisecond
+	cfgHandler.Handshake.Timeout = time.Second
+	cfgHandler.Read.Limit = 1 << 10
+	cfgHandler.Status.Interval = time.Minute
+	cfgHandler.Status.Timeout = time.Second
+	conv := converter.NewService("com_awakari_websocket_v1")
+	hf := handler.NewFactory(cfgApi, cfgHandler, conv, pub.NewMock(), storage.NewMockStorage(), slog.Default())
+	hFailed := hf(url, model.Stream{})
+	hFailed.Handle(context.Background())
+	require.Equal(t, model.StateFailed, hFailed.Status().State)
+	handlerByUrl := map[string]handler.Handler{
+		url: hFailed,
+	}
+	lock := &sync.Mutex{}
+	s := NewService(storage.NewMockStorage(), 1, lock, handlerByUrl, hf, cfgHandler)
+	accepting.Store(true)
+	require.Nil(t, s.Resume(context.TODO(), url, "group0", "user1"))
+	lock.Lock()
+	h := handlerByUrl[url]
+	lock.Unlock()
+	t.Cleanup(func() {
+		_ = h.Close()
+	})
+	assert.NotSame(t, hFailed, h)
+	// the replaced handler is closed
+	assert.Equal(t, model.StateStopped, hFailed.Status().State)
+	assert.Eventually(t, func() bool {
+		return h.Status().State == model.StateSubscribed
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/awakari/source-websocket/config"
+	"github.com/awakari/source-websocket/model"
+	"github.com/awakari/source-websocket/service/handler"
+	"github.com/awakari/source-websocket/storage"
+	"github.com/cenkalti/backoff/v4"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// supervisor runs the handlers produced by the wrapped factory, recovers their panics and restarts them with a backoff,
+// so a failure of a single stream handler never takes the whole process down.
+type supervisor struct {
+	url        string
+	str        model.Stream
+	hf         handler.Factory
+	stor       storage.Storage
+	cfgHandler config.HandlerConfig
+	log        *slog.Logger
+
+	stopCtx context.Context
+	stop    context.CancelFunc
+	done    chan struct{}
+
+	lock    sync.Mutex
+	started bool
+	h       handler.Handler
+	// restarting is set while waiting before the restart, the status of the panicked handler is in the st then
+	restarting bool
+	st         model.Status
+}
+
+func NewSupervisorFactory(hf handler.Factory, stor storage.Storage, cfgHandler config.HandlerConfig, log *slog.Logger) handler.Factory {
+	return func(url string, str model.Stream) handler.Handler {
+		stopCtx, stop := context.WithCancel(context.Background())
+		return &supervisor{
+			url:        url,
+			str:        str,
+			hf:         hf,
+			stor:       stor,
+			cfgHandler: cfgHandler,
+			log:        log,
+			stopCtx:    stopCtx,
+			stop:       stop,
+			done:       make(chan struct{}),
+			h:          hf(url, str),
+		}
+	}
+}
+
+func (s *supervisor) Close() (err error) {
+	s.stop()
+	s.lock.Lock()
+	started := s.started
+	h := s.h
+	s.lock.Unlock()
+	err = h.Close()
+	if started {
+		<-s.done
+	}
+	return
+}
+
+func (s *supervisor) Handle(ctx context.Context) {
+	s.lock.Lock()
+	if s.started || s.stopCtx.Err() != nil {
+		s.lock.Unlock()
+		return
+	}
+	s.started = true
+	h := s.h
+	s.lock.Unlock()
+	defer close(s.done)
+	b := backoff.NewExponentialBackOff(
+		backoff.WithInitialInterval(min(backoff.DefaultInitialInterval, s.cfgHandler.Restart.IntervalMax)),
+		backoff.WithMaxInterval(s.cfgHandler.Restart.IntervalMax),
+		backoff.WithMaxElapsedTime(0),
+	)
+	for {
+		t := time.Now()
+		p, stack := s.run(ctx, h)
+		if p == nil || s.stopCtx.Err() != nil || ctx.Err() != nil {
+			break
+		}
+		if time.Since(t) > s.cfgHandler.Restart.IntervalMax {
+			// the handler was running long enough to not consider it crash looping
+			b.Reset()
+		}
+		delay := b.NextBackOff()
+		s.log.Error(fmt.Sprintf("handler for %s panicked, restarting in %s, cause: %v\n%s", s.url, delay, p, stack))
+		st := s.recordPanic(h.Status(), p, stack)
+		select {
+		case <-ctx.Done():
+		case <-s.stopCtx.Done():
+		case <-time.After(delay):
+		}
+		if s.stopCtx.Err() != nil || ctx.Err() != nil {
+			break
+		}
+		str := s.str
+		str.Status = st
+		h = s.hf(s.url, str)
+		s.lock.Lock()
+		s.h = h
+		s.restarting = false
+		s.lock.Unlock()
+		// Close might have taken the previous handler
+		if s.stopCtx.Err() != nil {
+			break
+		}
+	}
+}
+
+func (s *supervisor) Status() (st model.Status) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	switch s.restarting {
+	case true:
+		st = s.st
+	default:
+		st = s.h.Status()
+	}
+	return
+}
+
+func (s *supervisor) run(ctx context.Context, h handler.Handler) (p any, stack []byte) {
+	defer func() {
+		if p = recover(); p != nil {
+			stack = debug.Stack()
+		}
+	}()
+	h.Handle(ctx)
+	return
+}
+
+func (s *supervisor) recordPanic(st model.Status, p any, stack []byte) model.Status {
+	t := time.Now().UTC()
+	st.State = model.StateBackingOff
+	st.UpdatedAt = t
+	st.LastErrorAt = t
+	st.LastError = fmt.Sprintf("panic: %v\n%s", p, stack)
+	st.CountErrors++
+	s.lock.Lock()
+	s.restarting = true
+	s.st = st
+	s.lock.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfgHandler.Status.Timeout)
+	defer cancel()
+	err := s.stor.UpdateStatus(ctx, s.url, st)
+	if err != nil {
+		s.log.Warn(fmt.Sprintf("failed to update the status of the stream %s: %s", s.url, err))
+	}
+	return st
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"github.com/awakari/source-websocket/config"
+	"github.com/awakari/source-websocket/model"
+	"github.com/awakari/source-websocket/service/handler"
+	"github.com/awakari/source-websocket/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type panickingHandler struct {
+	panics bool
+	stop   chan struct{}
+}
+
+func (p *panickingHandler) Close() error {
+	close(p.stop)
+	return nil
+}
+
+func (p *panickingHandler) Handle(ctx context.Context) {
+	if p.panics {
+		var m map[string]any
+		m["boom"] = 1
+	}
+	<-p.stop
+}
+
+func (p *panickingHandler) Status() (st model.Status) {
+	st.State = model.StateReceiving
+	return
+}
+
+func TestSupervisor_Handle(t *testing.T) {
+	var count atomic.Int32
+	var strLast atomic.Value
+	hf := func(url string, str model.Stream) handler.Handler {
+		strLast.Store(str)
+		return &panickingHandler{
+			panics: count.Add(1) == 1,
+			stop:   make(chan struct{}),
+		}
+	}
+	var cfgHandler config.HandlerConfig
+	cfgHandler.Restart.IntervalMax = 10 * time.Millisecond
+	cfgHandler.Status.Timeout = time.Second
+	h := NewSupervisorFactory(hf, storage.NewMockStorage(), cfgHandler, slog.Default())("url0", model.Stream{})
+	done := make(chan struct{})
+	go func() {
+		h.Handle(context.Background())
+		close(done)
+	}()
+	require.Eventually(t, func() bool {
+		return count.Load() == 2
+	}, time.Second, time.Millisecond)
+	str := strLast.Load().(model.Stream)
+	assert.Equal(t, model.StateBackingOff, str.Status.State)
+	assert.Contains(t, str.Status.LastError, "panic: assignment to entry in nil map")
+	assert.Contains(t, str.Status.LastError, "panickingHandler")
+	assert.Equal(t, uint64(1), str.Status.CountErrors)
+	assert.Equal(t, model.StateReceiving, h.Status().State)
+	assert.Nil(t, h.Close())
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("supervisor is not stopped")
+	}
+}
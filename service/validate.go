@@ -0,0 +1,95 @@
+package service
+
+import (
+	"fmt"
+	"github.com/awakari/source-websocket/config"
+	"github.com/awakari/source-websocket/model"
+	"github.com/awakari/source-websocket/service/handler"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// reservedHeaders are set by the websocket client itself and can not be overridden.
+var reservedHeaders = map[string]bool{
+	"Connection":               true,
+	"Host":                     true,
+	"Sec-Websocket-Extensions": true,
+	"Sec-Websocket-Key":        true,
+	"Sec-Websocket-Protocol":   true,
+	"Sec-Websocket-Version":    true,
+	"Upgrade":                  true,
+}
+
+const keepaliveMin = time.Second
+
+func validate(str model.Stream, cfgHandler config.HandlerConfig) (err error) {
+	err = validateHeaders(str.Headers)
+	if err == nil {
+		err = validateSubprotocols(str.Subprotocols)
+	}
+	if err == nil {
+		err = validateKeepalive(str.Keepalive)
+	}
+	if err == nil {
+		err = handler.Validate(str, cfgHandler)
+		if err != nil {
+			err = fmt.Errorf("%w: %s", ErrInvalid, err)
+		}
+	}
+	return
+}
+
+func validateHeaders(hdrs []model.Header) (err error) {
+	for _, hdr := range hdrs {
+		switch {
+		case !isToken(hdr.Name):
+			err = fmt.Errorf("%w: invalid header name: %q", ErrInvalid, hdr.Name)
+		case reservedHeaders[http.CanonicalHeaderKey(hdr.Name)]:
+			err = fmt.Errorf("%w: header %s is reserved", ErrInvalid, hdr.Name)
+		case strings.ContainsAny(hdr.Value, "\r\n\x00"):
+			err = fmt.Errorf("%w: invalid value of the header %s", ErrInvalid, hdr.Name)
+		}
+		if err != nil {
+			break
+		}
+	}
+	return
+}
+
+func validateSubprotocols(subprotocols []string) (err error) {
+	for _, sp := range subprotocols {
+		if !isToken(sp) {
+			err = fmt.Errorf("%w: invalid subprotocol: %q", ErrInvalid, sp)
+			break
+		}
+	}
+	return
+}
+
+func validateKeepalive(ka model.Keepalive) (err error) {
+	switch {
+	case ka.PingInterval > 0 && ka.PingInterval < keepaliveMin:
+		err = fmt.Errorf("%w: ping interval %s is less than %s", ErrInvalid, ka.PingInterval, keepaliveMin)
+	case ka.IdleTimeout > 0 && ka.IdleTimeout < keepaliveMin:
+		err = fmt.Errorf("%w: idle timeout %s is less than %s", ErrInvalid, ka.IdleTimeout, keepaliveMin)
+	}
+	return
+}
+
+// isToken checks the header name or the subprotocol is a valid RFC 7230 token.
+func isToken(s string) (ok bool) {
+	ok = s != ""
+	for _, c := range s {
+		if !ok {
+			break
+		}
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.ContainsRune("!#$%&'*+-.^_`|~", c):
+		default:
+			ok = false
+		}
+	}
+	return
+}
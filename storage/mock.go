@@ -23,6 +23,8 @@ func (m mockStorage) Create(ctx context.Context, url string, str model.Stream) (
 		err = ErrUnexpected
 	case "conflict":
 		err = ErrConflict
+	case "no_secret_key":
+		err = ErrSecret
 	}
 	return
 }
@@ -38,6 +40,28 @@ func (m mockStorage) Read(ctx context.Context, url string) (str model.Stream, er
 		str.UserId = "user1"
 		str.CreatedAt = time.Date(2024, 11, 4, 14, 52, 0, 0, time.UTC)
 		str.Replica = 1
+		if url == "other_replica" {
+			str.Replica = 2
+		}
+		str.Status = model.Status{
+			State:         model.StateReceiving,
+			UpdatedAt:     time.Date(2024, 11, 4, 14, 53, 0, 0, time.UTC),
+			ConnectedAt:   time.Date(2024, 11, 4, 14, 52, 1, 0, time.UTC),
+			LastMessageAt: time.Date(2024, 11, 4, 14, 53, 0, 0, time.UTC),
+			CountMessages: 42,
+			CountEvents:   41,
+			CountErrors:   1,
+		}
+	}
+	return
+}
+
+func (m mockStorage) UpdateStatus(ctx context.Context, url string, st model.Status) (err error) {
+	switch url {
+	case "missing":
+		err = ErrNotFound
+	case "fail":
+		err = ErrUnexpected
 	}
 	return
 }
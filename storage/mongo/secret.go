@@ -0,0 +1,72 @@
+package mongo
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// secrets encrypts the secret values before storing them, nil aead means no key is configured.
+type secrets struct {
+	aead cipher.AEAD
+}
+
+var errNoSecretKey = errors.New("secret key is not configured")
+
+func newSecrets(keyB64 string) (s secrets, err error) {
+	if keyB64 != "" {
+		var key []byte
+		key, err = base64.StdEncoding.DecodeString(keyB64)
+		var block cipher.Block
+		if err == nil {
+			block, err = aes.NewCipher(key)
+		}
+		if err == nil {
+			s.aead, err = cipher.NewGCM(block)
+		}
+		if err != nil {
+			err = fmt.Errorf("invalid secret key: %w", err)
+		}
+	}
+	return
+}
+
+func (s secrets) encrypt(plain string) (enc string, err error) {
+	if s.aead == nil {
+		err = errNoSecretKey
+	}
+	var nonce []byte
+	if err == nil {
+		nonce = make([]byte, s.aead.NonceSize())
+		_, err = rand.Read(nonce)
+	}
+	if err == nil {
+		enc = base64.StdEncoding.EncodeToString(s.aead.Seal(nonce, nonce, []byte(plain), nil))
+	}
+	return
+}
+
+func (s secrets) decrypt(enc string) (plain string, err error) {
+	if s.aead == nil {
+		err = errNoSecretKey
+	}
+	var data []byte
+	if err == nil {
+		data, err = base64.StdEncoding.DecodeString(enc)
+	}
+	if err == nil && len(data) < s.aead.NonceSize() {
+		err = errors.New("encrypted value is too short")
+	}
+	var plainData []byte
+	if err == nil {
+		nonceSize := s.aead.NonceSize()
+		plainData, err = s.aead.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+	}
+	if err == nil {
+		plain = string(plainData)
+	}
+	return
+}
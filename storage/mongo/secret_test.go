@@ -0,0 +1,58 @@
+package mongo
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestSecrets(t *testing.T) {
+	cases := map[string]struct {
+		key      string
+		plain    string
+		errNew   bool
+		errCrypt error
+	}{
+		"aes-256": {
+			key:   "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=",
+			plain: "Bearer token0",
+		},
+		"aes-128 empty value": {
+			key: "MDEyMzQ1Njc4OWFiY2RlZg==",
+		},
+		"no key": {
+			plain:    "Bearer token0",
+			errCrypt: errNoSecretKey,
+		},
+		"invalid key length": {
+			key:    "MDEyMzQ1Njc4OQ==",
+			errNew: true,
+		},
+		"invalid key encoding": {
+			key:    "not base64",
+			errNew: true,
+		},
+	}
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			s, err := newSecrets(c.key)
+			if c.errNew {
+				assert.Error(t, err)
+				return
+			}
+			require.Nil(t, err)
+			var enc string
+			enc, err = s.encrypt(c.plain)
+			assert.ErrorIs(t, err, c.errCrypt)
+			if c.errCrypt == nil {
+				if c.plain != "" {
+					assert.NotContains(t, enc, c.plain)
+				}
+				var dec string
+				dec, err = s.decrypt(enc)
+				assert.Nil(t, err)
+				assert.Equal(t, c.plain, dec)
+			}
+		})
+	}
+}
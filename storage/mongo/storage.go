@@ -15,18 +15,104 @@ import (
 )
 
 type storageMongo struct {
-	conn *mongo.Client
-	db   *mongo.Database
-	coll *mongo.Collection
+	conn    *mongo.Client
+	db      *mongo.Database
+	coll    *mongo.Collection
+	secrets secrets
 }
 
 type record struct {
-	Url          string    `bson:"url"`
-	Req          string    `bson:"req"`
-	GroupId      string    `bson:"gid"`
-	UserId       string    `bson:"uid"`
-	CreatedAt    time.Time `bson:"createdAt"`
-	ReplicaIndex uint32    `bson:"ridx"`
+	Url            string          `bson:"url"`
+	Req            string          `bson:"req"`
+	GroupId        string          `bson:"gid"`
+	UserId         string          `bson:"uid"`
+	CreatedAt      time.Time       `bson:"createdAt"`
+	ReplicaIndex   uint32          `bson:"ridx"`
+	Status         status          `bson:"status"`
+	Headers        []header        `bson:"hdrs,omitempty"`
+	Format         int             `bson:"fmt,omitempty"`
+	Protobuf       *protobufSchema `bson:"pb,omitempty"`
+	Compression    compression     `bson:"cmp"`
+	ReadLimit      int64           `bson:"readLimit,omitempty"`
+	Split          string          `bson:"split,omitempty"`
+	PublishNonData bool            `bson:"publishNonData,omitempty"`
+	Converter      string          `bson:"conv,omitempty"`
+	Mappings       []mapping       `bson:"maps,omitempty"`
+	Filter         string          `bson:"filter,omitempty"`
+	Script         string          `bson:"script,omitempty"`
+	Template       string          `bson:"tmpl,omitempty"`
+	Subprotocols   []string        `bson:"subprotocols,omitempty"`
+	Keepalive      keepalive       `bson:"keepalive"`
+	Heartbeats     []heartbeat     `bson:"hbs,omitempty"`
+	Replies        []reply         `bson:"replies,omitempty"`
+	Handshake      []handshakeStep `bson:"hs,omitempty"`
+}
+
+type compression struct {
+	Deflate int `bson:"deflate,omitempty"`
+	Payload int `bson:"payload,omitempty"`
+}
+
+type protobufSchema struct {
+	Descriptors []byte `bson:"desc"`
+	Message     string `bson:"msg"`
+}
+
+type handshakeStep struct {
+	Message string        `bson:"msg"`
+	Ack     string        `bson:"ack,omitempty"`
+	Error   string        `bson:"err,omitempty"`
+	Timeout time.Duration `bson:"timeout,omitempty"`
+}
+
+type heartbeat struct {
+	Interval time.Duration `bson:"interval"`
+	Message  string        `bson:"msg"`
+}
+
+type mapping struct {
+	Path      string `bson:"path"`
+	Attribute string `bson:"attr"`
+	Type      int    `bson:"type,omitempty"`
+	Param     string `bson:"param,omitempty"`
+	Label     string `bson:"lbl,omitempty"`
+}
+
+type reply struct {
+	Match    string `bson:"match"`
+	Template string `bson:"tmpl"`
+}
+
+type keepalive struct {
+	PingInterval time.Duration `bson:"ping,omitempty"`
+	IdleTimeout  time.Duration `bson:"idle,omitempty"`
+}
+
+type header struct {
+	Name  string `bson:"name"`
+	Value string `bson:"val"`
+	// Secret header value is encrypted
+	Secret bool `bson:"secret,omitempty"`
+}
+
+type status struct {
+	State          int       `bson:"state"`
+	UpdatedAt      time.Time `bson:"updatedAt"`
+	ConnectedAt    time.Time `bson:"connectedAt"`
+	LastMessageAt  time.Time `bson:"lastMsgAt"`
+	LastErrorAt    time.Time `bson:"lastErrAt"`
+	LastError      string    `bson:"lastErr"`
+	Subprotocol    string    `bson:"subprotocol,omitempty"`
+	PongAt         time.Time `bson:"pongAt"`
+	PingRtt        int64     `bson:"pingRtt"`
+	CountMessages  int64     `bson:"countMsgs"`
+	CountEvents    int64     `bson:"countEvts"`
+	CountErrors    int64     `bson:"countErrs"`
+	CountIdle      int64     `bson:"countIdle"`
+	CountOversized int64     `bson:"countOversized"`
+	CountControl   int64     `bson:"countControl"`
+	CountUnknown   int64     `bson:"countUnknown"`
+	CountRejected  int64     `bson:"countRejected"`
 }
 
 const attrUrl = "url"
@@ -35,6 +121,24 @@ const attrGroupId = "gid"
 const attrUserId = "uid"
 const attrReplicaIndex = "ridx"
 const attrCreatedAt = "createdAt"
+const attrStatus = "status"
+const attrHeaders = "hdrs"
+const attrSubprotocols = "subprotocols"
+const attrKeepalive = "keepalive"
+const attrHeartbeats = "hbs"
+const attrReplies = "replies"
+const attrHandshake = "hs"
+const attrFormat = "fmt"
+const attrProtobuf = "pb"
+const attrCompression = "cmp"
+const attrReadLimit = "readLimit"
+const attrSplit = "split"
+const attrPublishNonData = "publishNonData"
+const attrConverter = "conv"
+const attrMappings = "maps"
+const attrFilter = "filter"
+const attrScript = "script"
+const attrTemplate = "tmpl"
 
 var optsSrvApi = options.ServerAPI(options.ServerAPIVersion1)
 var optsGet = options.
@@ -62,6 +166,78 @@ var projRead = bson.D{
 		Key:   attrCreatedAt,
 		Value: 1,
 	},
+	{
+		Key:   attrStatus,
+		Value: 1,
+	},
+	{
+		Key:   attrHeaders,
+		Value: 1,
+	},
+	{
+		Key:   attrSubprotocols,
+		Value: 1,
+	},
+	{
+		Key:   attrKeepalive,
+		Value: 1,
+	},
+	{
+		Key:   attrHeartbeats,
+		Value: 1,
+	},
+	{
+		Key:   attrReplies,
+		Value: 1,
+	},
+	{
+		Key:   attrHandshake,
+		Value: 1,
+	},
+	{
+		Key:   attrFormat,
+		Value: 1,
+	},
+	{
+		Key:   attrProtobuf,
+		Value: 1,
+	},
+	{
+		Key:   attrCompression,
+		Value: 1,
+	},
+	{
+		Key:   attrReadLimit,
+		Value: 1,
+	},
+	{
+		Key:   attrSplit,
+		Value: 1,
+	},
+	{
+		Key:   attrPublishNonData,
+		Value: 1,
+	},
+	{
+		Key:   attrConverter,
+		Value: 1,
+	},
+	{
+		Key:   attrMappings,
+		Value: 1,
+	},
+	{
+		Key:   attrFilter,
+		Value: 1,
+	},
+	{
+		Key:   attrScript,
+		Value: 1,
+	},
+	{
+		Key:   attrTemplate,
+		Value: 1,
+	},
 }
 var projList = bson.D{
 	{
@@ -98,8 +274,12 @@ func NewStorage(ctx context.Context, cfgDb config.DbConfig) (s storage.Storage,
 		}
 		clientOpts = clientOpts.SetAuth(auth)
 	}
-	conn, err := mongo.Connect(ctx, clientOpts)
 	var sm storageMongo
+	sm.secrets, err = newSecrets(cfgDb.Secret.Key)
+	var conn *mongo.Client
+	if err == nil {
+		conn, err = mongo.Connect(ctx, clientOpts)
+	}
 	if err == nil {
 		db := conn.Database(cfgDb.Name)
 		coll := db.Collection(cfgDb.Table.Name)
@@ -135,14 +315,71 @@ func (sm storageMongo) Close() error {
 }
 
 func (sm storageMongo) Create(ctx context.Context, url string, str model.Stream) (err error) {
-	_, err = sm.coll.InsertOne(ctx, record{
+	rec := record{
 		Url:          url,
 		Req:          str.Request,
 		GroupId:      str.GroupId,
 		UserId:       str.UserId,
 		ReplicaIndex: str.Replica,
 		CreatedAt:    str.CreatedAt.UTC(),
-	})
+		Status:       encodeStatus(str.Status),
+		Format:       int(str.Format),
+		Compression: compression{
+			Deflate: int(str.Compression.Deflate),
+			Payload: int(str.Compression.Payload),
+		},
+		ReadLimit:      str.ReadLimit,
+		Split:          str.Split,
+		PublishNonData: str.PublishNonData,
+		Converter:      str.Converter,
+		Filter:         str.Filter,
+		Script:         str.Script,
+		Template:       str.Template,
+		Subprotocols:   str.Subprotocols,
+		Keepalive: keepalive{
+			PingInterval: str.Keepalive.PingInterval,
+			IdleTimeout:  str.Keepalive.IdleTimeout,
+		},
+	}
+	if str.Format == model.FormatProtobuf {
+		rec.Protobuf = &protobufSchema{
+			Descriptors: str.Protobuf.Descriptors,
+			Message:     str.Protobuf.Message,
+		}
+	}
+	for _, hb := range str.Heartbeats {
+		rec.Heartbeats = append(rec.Heartbeats, heartbeat{
+			Interval: hb.Interval,
+			Message:  hb.Message,
+		})
+	}
+	for _, m := range str.Mappings {
+		rec.Mappings = append(rec.Mappings, mapping{
+			Path:      m.Path,
+			Attribute: m.Attribute,
+			Type:      int(m.Type),
+			Param:     m.Param,
+			Label:     m.Label,
+		})
+	}
+	for _, r := range str.Replies {
+		rec.Replies = append(rec.Replies, reply{
+			Match:    r.Match,
+			Template: r.Template,
+		})
+	}
+	for _, hs := range str.Handshake {
+		rec.Handshake = append(rec.Handshake, handshakeStep{
+			Message: hs.Message,
+			Ack:     hs.Ack,
+			Error:   hs.Error,
+			Timeout: hs.Timeout,
+		})
+	}
+	rec.Headers, err = sm.encodeHeaders(str.Headers)
+	if err == nil {
+		_, err = sm.coll.InsertOne(ctx, rec)
+	}
 	err = decodeError(err, url)
 	return
 }
@@ -164,11 +401,83 @@ func (sm storageMongo) Read(ctx context.Context, url string) (str model.Stream,
 		str.GroupId = rec.GroupId
 		str.UserId = rec.UserId
 		str.Replica = rec.ReplicaIndex
+		str.Status = decodeStatus(rec.Status)
+		str.Format = model.Format(rec.Format)
+		if rec.Protobuf != nil {
+			str.Protobuf.Descriptors = rec.Protobuf.Descriptors
+			str.Protobuf.Message = rec.Protobuf.Message
+		}
+		str.Compression.Deflate = model.DeflateMode(rec.Compression.Deflate)
+		str.Compression.Payload = model.PayloadCompression(rec.Compression.Payload)
+		str.ReadLimit = rec.ReadLimit
+		str.Split = rec.Split
+		str.PublishNonData = rec.PublishNonData
+		str.Converter = rec.Converter
+		str.Filter = rec.Filter
+		str.Script = rec.Script
+		str.Template = rec.Template
+		str.Subprotocols = rec.Subprotocols
+		str.Keepalive.PingInterval = rec.Keepalive.PingInterval
+		str.Keepalive.IdleTimeout = rec.Keepalive.IdleTimeout
+		for _, hb := range rec.Heartbeats {
+			str.Heartbeats = append(str.Heartbeats, model.Heartbeat{
+				Interval: hb.Interval,
+				Message:  hb.Message,
+			})
+		}
+		for _, m := range rec.Mappings {
+			str.Mappings = append(str.Mappings, model.Mapping{
+				Path:      m.Path,
+				Attribute: m.Attribute,
+				Type:      model.MappingType(m.Type),
+				Param:     m.Param,
+				Label:     m.Label,
+			})
+		}
+		for _, r := range rec.Replies {
+			str.Replies = append(str.Replies, model.Reply{
+				Match:    r.Match,
+				Template: r.Template,
+			})
+		}
+		for _, hs := range rec.Handshake {
+			str.Handshake = append(str.Handshake, model.HandshakeStep{
+				Message: hs.Message,
+				Ack:     hs.Ack,
+				Error:   hs.Error,
+				Timeout: hs.Timeout,
+			})
+		}
+		str.Headers, err = sm.decodeHeaders(rec.Headers)
 	}
 	err = decodeError(err, url)
 	return
 }
 
+func (sm storageMongo) UpdateStatus(ctx context.Context, url string, st model.Status) (err error) {
+	var result *mongo.UpdateResult
+	result, err = sm.coll.UpdateOne(
+		ctx,
+		bson.M{
+			attrUrl: url,
+		},
+		bson.M{
+			"$set": bson.M{
+				attrStatus: encodeStatus(st),
+			},
+		},
+	)
+	switch err {
+	case nil:
+		if result.MatchedCount < 1 {
+			err = fmt.Errorf("%w by url %s", storage.ErrNotFound, url)
+		}
+	default:
+		err = decodeError(err, url)
+	}
+	return
+}
+
 func (sm storageMongo) Delete(ctx context.Context, url, groupId, userId string) (err error) {
 	var result *mongo.DeleteResult
 	result, err = sm.coll.DeleteOne(ctx, bson.M{
@@ -245,6 +554,86 @@ func (sm storageMongo) List(ctx context.Context, limit uint32, filter model.Filt
 	return
 }
 
+func (sm storageMongo) encodeHeaders(src []model.Header) (dst []header, err error) {
+	for _, h := range src {
+		hdr := header{
+			Name:   h.Name,
+			Value:  h.Value,
+			Secret: h.Secret,
+		}
+		if h.Secret {
+			hdr.Value, err = sm.secrets.encrypt(h.Value)
+			if err != nil {
+				err = fmt.Errorf("%w: failed to encrypt the header %s: %s", storage.ErrSecret, h.Name, err)
+				break
+			}
+		}
+		dst = append(dst, hdr)
+	}
+	return
+}
+
+func (sm storageMongo) decodeHeaders(src []header) (dst []model.Header, err error) {
+	for _, hdr := range src {
+		h := model.Header{
+			Name:   hdr.Name,
+			Value:  hdr.Value,
+			Secret: hdr.Secret,
+		}
+		if hdr.Secret {
+			h.Value, err = sm.secrets.decrypt(hdr.Value)
+			if err != nil {
+				err = fmt.Errorf("%w: failed to decrypt the header %s: %s", storage.ErrSecret, hdr.Name, err)
+				break
+			}
+		}
+		dst = append(dst, h)
+	}
+	return
+}
+
+func encodeStatus(src model.Status) (dst status) {
+	dst.State = int(src.State)
+	dst.UpdatedAt = src.UpdatedAt.UTC()
+	dst.ConnectedAt = src.ConnectedAt.UTC()
+	dst.LastMessageAt = src.LastMessageAt.UTC()
+	dst.LastErrorAt = src.LastErrorAt.UTC()
+	dst.LastError = src.LastError
+	dst.Subprotocol = src.Subprotocol
+	dst.PongAt = src.PongAt.UTC()
+	dst.PingRtt = int64(src.PingRtt)
+	dst.CountMessages = int64(src.CountMessages)
+	dst.CountEvents = int64(src.CountEvents)
+	dst.CountErrors = int64(src.CountErrors)
+	dst.CountIdle = int64(src.CountIdleTimeouts)
+	dst.CountOversized = int64(src.CountOversized)
+	dst.CountControl = int64(src.CountControl)
+	dst.CountUnknown = int64(src.CountUnknown)
+	dst.CountRejected = int64(src.CountRejected)
+	return
+}
+
+func decodeStatus(src status) (dst model.Status) {
+	dst.State = model.State(src.State)
+	dst.UpdatedAt = src.UpdatedAt.UTC()
+	dst.ConnectedAt = src.ConnectedAt.UTC()
+	dst.LastMessageAt = src.LastMessageAt.UTC()
+	dst.LastErrorAt = src.LastErrorAt.UTC()
+	dst.LastError = src.LastError
+	dst.Subprotocol = src.Subprotocol
+	dst.PongAt = src.PongAt.UTC()
+	dst.PingRtt = time.Duration(src.PingRtt)
+	dst.CountMessages = uint64(src.CountMessages)
+	dst.CountEvents = uint64(src.CountEvents)
+	dst.CountErrors = uint64(src.CountErrors)
+	dst.CountIdleTimeouts = uint64(src.CountIdle)
+	dst.CountOversized = uint64(src.CountOversized)
+	dst.CountControl = uint64(src.CountControl)
+	dst.CountUnknown = uint64(src.CountUnknown)
+	dst.CountRejected = uint64(src.CountRejected)
+	return
+}
+
 func decodeError(src error, url string) (dst error) {
 	switch {
 	case src == nil:
@@ -252,6 +641,8 @@ func decodeError(src error, url string) (dst error) {
 		dst = fmt.Errorf("%w: %s", storage.ErrNotFound, url)
 	case mongo.IsDuplicateKeyError(src):
 		dst = fmt.Errorf("%w: %s", storage.ErrConflict, url)
+	case errors.Is(src, storage.ErrSecret):
+		dst = fmt.Errorf("%s: %w", url, src)
 	default:
 		dst = fmt.Errorf("%w: %s", storage.ErrUnexpected, src)
 	}
@@ -8,6 +8,7 @@ import (
 	"github.com/awakari/source-websocket/storage"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
 	"os"
 	"testing"
 	"time"
@@ -167,6 +168,195 @@ func TestStorageMongo_Read(t *testing.T) {
 	}
 }
 
+func TestStorageMongo_CreateRead(t *testing.T) {
+	//
+	collName := fmt.Sprintf("websocket-test-%d", time.Now().UnixMicro())
+	dbCfg := config.DbConfig{
+		Uri:  dbUri,
+		Name: "sources",
+	}
+	dbCfg.Secret.Key = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+	dbCfg.Table.Name = collName
+	dbCfg.Tls.Enabled = true
+	dbCfg.Tls.Insecure = true
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Hour)
+	defer cancel()
+	s, err := NewStorage(ctx, dbCfg)
+	require.Nil(t, err)
+	assert.NotNil(t, s)
+	//
+	sm := s.(storageMongo)
+	defer clear(ctx, t, s.(storageMongo))
+	//
+	hdrs := []model.Header{
+		{
+			Name:   "Authorization",
+			Value:  "Bearer token0",
+			Secret: true,
+		},
+		{
+			Name:  "Origin",
+			Value: "https://awakari.com",
+		},
+	}
+	str0 := model.Stream{
+		CreatedAt: time.Date(2024, 11, 4, 18, 49, 25, 0, time.UTC),
+		Request:   "sub0",
+		GroupId:   "group1",
+		UserId:    "user2",
+		Replica:   3,
+		Headers:   hdrs,
+		Format:    model.FormatProtobuf,
+		Protobuf: model.ProtobufSchema{
+			Descriptors: []byte{0x0a, 0x0b, 0x74, 0x72, 0x61, 0x64, 0x65},
+			Message:     "market.Trade",
+		},
+		Compression: model.Compression{
+			Deflate: model.DeflateNoContextTakeover,
+			Payload: model.PayloadGzip,
+		},
+		Split:     "data.trades",
+		Converter: "generic",
+		Mappings: []model.Mapping{
+			{
+				Path:      "p",
+				Attribute: "price",
+			},
+			{
+				Path:      "T",
+				Attribute: "time",
+				Type:      model.MappingTimestamp,
+				Param:     "ms",
+			},
+			{
+				Path:      "tags",
+				Attribute: "tags",
+				Type:      model.MappingJoined,
+				Param:     ",",
+				Label:     "Tags",
+			},
+		},
+		Filter:         "has(attrs.price) && double(attrs.price) > 1.0",
+		Template:       "{{.attrs.price}}",
+		Script:         "def transform(msg):\n    return None\n",
+		PublishNonData: true,
+		ReadLimit:      1 << 20,
+		Subprotocols: []string{
+			"graphql-transport-ws",
+			"graphql-ws",
+		},
+		Keepalive: model.Keepalive{
+			PingInterval: 30 * time.Second,
+			IdleTimeout:  2 * time.Minute,
+		},
+		Heartbeats: []model.Heartbeat{
+			{
+				Interval: 15 * time.Second,
+				Message:  `{"type":"ping"}`,
+			},
+		},
+		Replies: []model.Reply{
+			{
+				Match:    `{"type":"ping"}`,
+				Template: `{"type":"pong","id":{{.id}}}`,
+			},
+		},
+		Handshake: []model.HandshakeStep{
+			{
+				Message: `{"type":"connection_init"}`,
+				Ack:     `{"type":"connection_ack"}`,
+				Error:   `{"type":"connection_error"}`,
+				Timeout: 5 * time.Second,
+			},
+			{
+				Message: `{"type":"subscribe"}`,
+			},
+		},
+	}
+	err = s.Create(ctx, "url0", str0)
+	require.Nil(t, err)
+	//
+	var rec record
+	err = sm.coll.FindOne(ctx, bson.M{attrUrl: "url0"}).Decode(&rec)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(rec.Headers))
+	assert.NotEqual(t, "Bearer token0", rec.Headers[0].Value)
+	assert.Equal(t, "https://awakari.com", rec.Headers[1].Value)
+	//
+	var str model.Stream
+	str, err = s.Read(ctx, "url0")
+	assert.Nil(t, err)
+	assert.Equal(t, str0, str)
+}
+
+func TestStorageMongo_UpdateStatus(t *testing.T) {
+	//
+	collName := fmt.Sprintf("websocket-test-%d", time.Now().UnixMicro())
+	dbCfg := config.DbConfig{
+		Uri:  dbUri,
+		Name: "sources",
+	}
+	dbCfg.Table.Name = collName
+	dbCfg.Tls.Enabled = true
+	dbCfg.Tls.Insecure = true
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Hour)
+	defer cancel()
+	s, err := NewStorage(ctx, dbCfg)
+	require.Nil(t, err)
+	assert.NotNil(t, s)
+	//
+	sm := s.(storageMongo)
+	defer clear(ctx, t, s.(storageMongo))
+	//
+	_, err = sm.coll.InsertOne(ctx, record{
+		Url:          "url0",
+		Req:          "sub1",
+		GroupId:      "group2",
+		UserId:       "user3",
+		ReplicaIndex: 4,
+		CreatedAt:    time.Date(2024, 11, 4, 18, 49, 25, 0, time.UTC),
+	})
+	require.Nil(t, err)
+	//
+	cases := map[string]struct {
+		url string
+		st  model.Status
+		err error
+	}{
+		"ok": {
+			url: "url0",
+			st: model.Status{
+				State:         model.StateBackingOff,
+				UpdatedAt:     time.Date(2024, 11, 4, 18, 51, 0, 0, time.UTC),
+				ConnectedAt:   time.Date(2024, 11, 4, 18, 49, 26, 0, time.UTC),
+				LastMessageAt: time.Date(2024, 11, 4, 18, 50, 0, 0, time.UTC),
+				LastErrorAt:   time.Date(2024, 11, 4, 18, 51, 0, 0, time.UTC),
+				LastError:     "connection reset by peer",
+				CountMessages: 3,
+				CountEvents:   2,
+				CountErrors:   1,
+			},
+		},
+		"missing": {
+			url: "url1",
+			err: storage.ErrNotFound,
+		},
+	}
+	//
+	for k, c := range cases {
+		t.Run(k, func(t *testing.T) {
+			err = s.UpdateStatus(ctx, c.url, c.st)
+			assert.ErrorIs(t, err, c.err)
+			if c.err == nil {
+				var str model.Stream
+				str, err = s.Read(ctx, c.url)
+				assert.Nil(t, err)
+				assert.Equal(t, c.st, str.Status)
+			}
+		})
+	}
+}
+
 func TestStorageMongo_Delete(t *testing.T) {
 	//
 	collName := fmt.Sprintf("websocket-test-%d", time.Now().UnixMicro())
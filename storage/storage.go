@@ -11,6 +11,7 @@ type Storage interface {
 	io.Closer
 	Create(ctx context.Context, url string, str model.Stream) (err error)
 	Read(ctx context.Context, url string) (str model.Stream, err error)
+	UpdateStatus(ctx context.Context, url string, st model.Status) (err error)
 	Delete(ctx context.Context, url, groupId, userId string) (err error)
 	List(ctx context.Context, limit uint32, filter model.Filter, order model.Order, cursor string) (urls []string, err error)
 }
@@ -18,3 +19,6 @@ type Storage interface {
 var ErrNotFound = errors.New("not found")
 var ErrConflict = errors.New("conflict")
 var ErrUnexpected = errors.New("unexpected error")
+
+// ErrSecret means the secret header can't be encrypted or decrypted, e.g. the secret key is not configured or changed.
+var ErrSecret = errors.New("secret header failure")